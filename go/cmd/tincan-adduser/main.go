@@ -0,0 +1,141 @@
+// tincan/cmd/tincan-adduser/main.go
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+
+	"tincan/internal/server"
+)
+
+const (
+	usersFile  = "config/users.json"
+	scramIters = 4096
+	scramSalt  = 16 // bytes
+)
+
+// tincan-adduser generates SCRAM-SHA-256 and bcrypt credentials for a user
+// and writes them into config/users.json, so operators never have to store
+// a plaintext password file.
+func main() {
+	usernameFlag := flag.String("user", "", "username to add or update")
+	certFPFlag := flag.String("certfp", "", "optional hex SHA-256 client cert fingerprint, for CERTFP auth")
+	operatorFlag := flag.Bool("operator", false, "grant this user permission to run admin commands (e.g. RELOAD)")
+	flag.Parse()
+
+	username := strings.TrimSpace(*usernameFlag)
+	if username == "" {
+		fmt.Fprintln(os.Stderr, "usage: tincan-adduser -user <username> [-certfp <hex>] [-operator]")
+		os.Exit(1)
+	}
+
+	password := readPassword()
+
+	rec, err := buildUserRecord(username, password, *certFPFlag, *operatorFlag)
+	if err != nil {
+		log.Fatalf("Could not build credentials for %s: %v", username, err)
+	}
+
+	if err := upsertUser(rec); err != nil {
+		log.Fatalf("Could not update %s: %v", usersFile, err)
+	}
+
+	fmt.Printf("User '%s' added to %s.\n", username, usersFile)
+}
+
+func readPassword() string {
+	fmt.Print("Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		log.Fatalf("Could not read password: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// buildUserRecord derives the bcrypt hash and SCRAM-SHA-256 stored/server
+// keys from password following RFC 5802, so the plaintext password never
+// touches config/users.json.
+func buildUserRecord(username, password, certFP string, operator bool) (*server.UserRecord, error) {
+	salt := make([]byte, scramSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+
+	saltedPassword := pbkdf2.Key([]byte(password), salt, scramIters, sha256.Size, sha256.New)
+	clientKey := hmacSum(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := hmacSum(saltedPassword, "Server Key")
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("hashing password: %w", err)
+	}
+
+	return &server.UserRecord{
+		Username:       username,
+		BcryptHash:     bcryptHash,
+		ScramSalt:      salt,
+		ScramIterCount: scramIters,
+		ScramStoredKey: storedKey[:],
+		ScramServerKey: serverKey,
+		CertFP:         certFP,
+		Operator:       operator,
+	}, nil
+}
+
+func hmacSum(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// upsertUser reads the existing config/users.json (if any), replaces or
+// appends rec by username, and writes the result back atomically via a
+// temp file + rename, the same pattern history.JSONStore uses for
+// last_seen.json.
+func upsertUser(rec *server.UserRecord) error {
+	var records []*server.UserRecord
+
+	data, err := os.ReadFile(usersFile)
+	if err == nil {
+		if jsonErr := json.Unmarshal(data, &records); jsonErr != nil {
+			return fmt.Errorf("parsing existing %s: %w", usersFile, jsonErr)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("reading %s: %w", usersFile, err)
+	}
+
+	replaced := false
+	for i, existing := range records {
+		if existing.Username == rec.Username {
+			records[i] = rec
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		records = append(records, rec)
+	}
+
+	out, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", usersFile, err)
+	}
+
+	tmpPath := usersFile + ".tmp"
+	if err := os.WriteFile(tmpPath, out, 0600); err != nil {
+		return fmt.Errorf("writing %s: %w", tmpPath, err)
+	}
+	return os.Rename(tmpPath, usersFile)
+}
@@ -3,14 +3,16 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
-	"log"
 	"os"
 	"strings"
 	"sync" // For a waitgroup to keep main alive while core processes
 	"time" // For potential sleep/delays
 
 	"tincan/internal/client/core" // Path to your client core package
+	"tincan/internal/logging"
+	"tincan/internal/proto"
 )
 
 const (
@@ -19,18 +21,31 @@ const (
 	ConsoleGroupNameMaxLen = core.CoreGroupNameMaxLen
 	ServerIP               = "127.0.0.1" // Default server IP
 	ServerPort             = 8080        // Default server port
+	historyAutoLoadCount   = 20          // Lines of global history fetched automatically on login
 )
 
 var (
 	clientCore                 *core.ClientCore
+	commandRegistry            *core.CommandRegistry
 	userInputReader            *bufio.Reader
 	isWaitingForUsernamePrompt = false
+	isWaitingForPasswordPrompt = false
 	isAppRunning               = true
 	myUsernameUI               = "" // To store the username for the prompt
+	flowControlHint            = "" // Set by consoleOnFlowControl, shown alongside the prompt
 	cliMutex                   sync.Mutex
 	shutdownWg                 sync.WaitGroup // To wait for core to shutdown cleanly
 )
 
+// printPrompt shows the logged-in prompt, plus flowControlHint if
+// consoleOnFlowControl last reported a queued send.
+func printPrompt(username string) {
+	cliMutex.Lock()
+	hint := flowControlHint
+	cliMutex.Unlock()
+	fmt.Printf("%s>%s ", username, hint)
+}
+
 // --- Callback Implementations ---
 
 func consoleOnStatusChange(statusMessage string) {
@@ -49,7 +64,7 @@ func consoleOnStatusChange(statusMessage string) {
 	cliMutex.Unlock()
 
 	if loggedIn && usernameForPrompt != "" {
-		fmt.Printf("%s> ", usernameForPrompt)
+		printPrompt(usernameForPrompt)
 	}
 }
 
@@ -64,7 +79,7 @@ func consoleOnMessageReceived(messageLine string) {
 	cliMutex.Unlock()
 
 	if loggedIn && usernameForPrompt != "" {
-		fmt.Printf("%s> ", usernameForPrompt)
+		printPrompt(usernameForPrompt)
 	}
 }
 
@@ -77,9 +92,50 @@ func consoleOnUsernameRequested() {
 	fmt.Print("Enter username: ") // Initial prompt
 }
 
+func consoleOnPasswordRequested() {
+	cliMutex.Lock()
+	isWaitingForPasswordPrompt = true // Signal main loop to prompt for password
+	cliMutex.Unlock()
+	fmt.Print("Password: ")
+}
+
+// consoleOnFlowControl reports outbound rate limiting (see
+// core.OnFlowControlFunc): pending > 0 means a send is queued behind the
+// local verbLimiter, and delay > 0 with pending 0 comes from a server
+// FAIL RATELIMIT. The hint stays on the prompt until a later callback
+// reports pending 0 and delay 0, which happens when the local queue
+// drains; a FAIL RATELIMIT hint is not cleared when its delay passes.
+func consoleOnFlowControl(pending int, delay time.Duration) {
+	cliMutex.Lock()
+	if pending > 0 || delay > 0 {
+		flowControlHint = fmt.Sprintf(" [rate limited, %d queued, retry in %.1fs]", pending, delay.Seconds())
+	} else {
+		flowControlHint = ""
+	}
+	cliMutex.Unlock()
+}
+
+// consoleOnReconnecting prints a banner ahead of each reconnect attempt
+// (see core.OnReconnectingFunc); consoleOnStatusChange also gets a plain-
+// text line for the same event, so this is purely a friendlier restated
+// version of it for scripts/UIs that want the structured fields instead
+// of parsing status text.
+func consoleOnReconnecting(attempt int, nextDelay time.Duration) {
+	fmt.Printf("--- reconnecting: attempt %d, next try in %.1fs ---\n", attempt, nextDelay.Seconds())
+}
+
+// consoleOnLatency logs the round trip of each keepalive PING/PONG (see
+// core.OnLatencyFunc) at debug level rather than printing it - it fires
+// every keepalive interval, too often for the main conversation view,
+// but useful for diagnosing a flaky connection via --log-level debug.
+func consoleOnLatency(rtt time.Duration) {
+	logging.Debug("keepalive PONG received", logging.F("rtt_ms", rtt.Milliseconds()))
+}
+
 func consoleOnError(err error, context string) {
-	log.Printf("ClientCore Error (%s): %v\n", context, err)
-	// Potentially trigger a shutdown or specific UI update based on error
+	// core.ClientCore already logs this (with context as a field) before
+	// invoking the callback; nothing left to do here but react in the UI
+	// if a future change needs to.
 }
 
 func consoleOnLoginSuccess(username string) {
@@ -88,27 +144,142 @@ func consoleOnLoginSuccess(username string) {
 	myUsernameUI = username            // Set the username for the prompt
 	isWaitingForUsernamePrompt = false // Ensure this is false
 	cliMutex.Unlock()
+
+	if err := clientCore.RequestHistory("", "", historyAutoLoadCount); err != nil {
+		logging.Error(err.Error(), logging.F("context", "RequestHistory"))
+	}
+
 	// Re-display prompt
-	fmt.Printf("%s> ", username)
+	printPrompt(username)
+}
+
+// consoleOnHistoryBatch prints a CHATHISTORY response (see
+// core.OnHistoryBatchFunc): target is "" for the global buffer, as
+// requested both on login and by /history with no argument.
+func consoleOnHistoryBatch(target string, messages []core.HistoricalMessage) {
+	if len(messages) == 0 {
+		return
+	}
+	label := target
+	if label == "" {
+		label = "global"
+	}
+	fmt.Printf("--- history: %s ---\n", label)
+	for _, m := range messages {
+		fmt.Printf("[%s] %s: %s\n", m.Timestamp.Local().Format("15:04:05"), m.From, m.Text)
+	}
+	fmt.Println("--- end history ---")
+
+	cliMutex.Lock()
+	usernameForPrompt := myUsernameUI
+	loggedIn := clientCore != nil && clientCore.IsLoggedIn()
+	cliMutex.Unlock()
+	if loggedIn && usernameForPrompt != "" {
+		printPrompt(usernameForPrompt)
+	}
+}
+
+// setupLogging builds a Logger from --log-level/--log-format/--log-file
+// and installs it as the package default, so both this file's own
+// logging.* calls and core.ClientCore's internal error reporting (see
+// reportError in internal/client/core) share one configured sink set.
+func setupLogging(levelFlag, formatFlag, fileFlag string) error {
+	level, err := logging.ParseLevel(levelFlag)
+	if err != nil {
+		return err
+	}
+	asJSON := formatFlag == "json"
+
+	var sinks []logging.Sink
+	if asJSON {
+		sinks = append(sinks, logging.NewJSONSink(os.Stderr))
+	} else {
+		sinks = append(sinks, logging.NewConsoleSink(os.Stderr))
+	}
+	if fileFlag != "" {
+		fileSink, err := logging.NewRotatingFileSink(fileFlag, 10<<20, 5, 30*24*time.Hour, asJSON)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, fileSink)
+	}
+
+	logging.SetDefault(logging.New(level, sinks...))
+	return nil
 }
 
 func main() {
-	log.Println("Starting Tincan CLI client...")
+	logLevel := flag.String("log-level", "info", "Minimum log level: debug, info, warn, error")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	logFile := flag.String("log-file", "", "Additional log file path (rotated at 10MB, 5 backups, 30 days)")
+	transportFlag := flag.String("transport", "tcp", "Connection transport: tcp, ws, wss, or auto (try ws, fall back to tcp)")
+	keepaliveInterval := flag.Duration("keepalive-interval", 30*time.Second, "Keepalive PING interval (0 disables keepalive)")
+	keepaliveTimeout := flag.Duration("keepalive-timeout", 10*time.Second, "How long to wait for a PONG before treating the connection as dead")
+	codecFlag := flag.String("codec", proto.SubprotocolText, "Wire codec subprotocol to negotiate: tincan.v1.text or tincan.v1.proto")
+	historyDBFlag := flag.String("history-db", "tincan-history.db", "BoltDB file used to cache message history across restarts (empty disables the cache)")
+	historyMaxMessages := flag.Int("history-max-messages", 500, "Max cached messages kept per channel (0 disables the count-based cap)")
+	historyMaxAge := flag.Duration("history-max-age", 30*24*time.Hour, "Max age of a cached message before the background purger removes it (0 disables age-based purging)")
+	flag.Parse()
+
+	if err := setupLogging(*logLevel, *logFormat, *logFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	transportKind, err := core.ParseTransportKind(*transportFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --transport flag: %v\n", err)
+		os.Exit(1)
+	}
+
+	logging.Info("Starting Tincan CLI client...")
+	if *codecFlag != proto.SubprotocolText {
+		// No server in this deployment speaks anything but SubprotocolText
+		// yet (see internal/proto's package doc); warn rather than fail
+		// outright; if the handshake flat out rejects the subprotocol,
+		// x/net/websocket.Dial will fail already and the user will hear
+		// about it the same way any connect failure reports.
+		logging.Warn("Requesting a non-default codec; no bundled server understands it yet", logging.F("codec", *codecFlag))
+	}
 	userInputReader = bufio.NewReader(os.Stdin)
 
 	clientCore = core.NewClientCore(
 		consoleOnStatusChange,
 		consoleOnMessageReceived,
 		consoleOnUsernameRequested,
+		consoleOnPasswordRequested,
 		consoleOnError,
 		consoleOnLoginSuccess, // Added
 	)
+	clientCore.EnableResume(true)
+	clientCore.SetReconnectPolicy(1*time.Second, 30*time.Second, 0, 1*time.Second)
+	clientCore.SetOnFlowControl(consoleOnFlowControl)
+	clientCore.SetOnHistoryBatch(consoleOnHistoryBatch)
+	clientCore.SetOnReconnecting(consoleOnReconnecting)
+	clientCore.SetOnLatency(consoleOnLatency)
+	clientCore.SetKeepalive(*keepaliveInterval, *keepaliveTimeout)
+	clientCore.SetTransportKind(transportKind)
+	if err := clientCore.SetCodec(*codecFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --codec flag: %v\n", err)
+		os.Exit(1)
+	}
+	retention := core.HistoryRetention{MaxMessages: *historyMaxMessages, MaxAge: *historyMaxAge}
+	if *historyDBFlag != "" {
+		store, err := core.NewBoltHistoryStore(*historyDBFlag, retention)
+		if err != nil {
+			logging.Error(err.Error(), logging.F("context", "NewBoltHistoryStore"))
+		} else {
+			clientCore.SetHistoryStore(store)
+			clientCore.SetHistoryRetention(retention)
+		}
+	}
+	commandRegistry = core.NewDefaultCommandRegistry(clientCore, func(s string) { fmt.Println(s) })
 	shutdownWg.Add(1) // For the clientCore's lifecycle
 
 	// Attempt to connect
-	err := clientCore.Connect(ServerIP, ServerPort)
+	err = clientCore.Connect(ServerIP, ServerPort)
 	if err != nil {
-		log.Printf("Failed to initiate connection: %v. Exiting.", err)
+		logging.Error(err.Error(), logging.F("context", "Connect"))
 		clientCore.Cleanup()
 		shutdownWg.Done() // Decrement if connect fails before goroutines start
 		return
@@ -118,26 +289,27 @@ func main() {
 	for isAppRunning {
 		cliMutex.Lock()
 		waitingForUser := isWaitingForUsernamePrompt
+		waitingForPassword := isWaitingForPasswordPrompt
 		loggedIn := clientCore.IsLoggedIn()
 		currentUsername := myUsernameUI
 		cliMutex.Unlock()
 
-		if !clientCore.IsConnected() && !loggedIn {
+		if !clientCore.IsConnected() && !loggedIn && !clientCore.IsReconnecting() {
 			// If we disconnected and weren't trying to log in, maybe exit
 			// Give a small grace period for disconnect messages to print
 			time.Sleep(100 * time.Millisecond)
-			if !clientCore.IsConnected() { // Check again
-				log.Println("Connection lost and not in login phase. Exiting.")
+			if !clientCore.IsConnected() && !clientCore.IsReconnecting() { // Check again
+				logging.Info("Connection lost and not in login phase. Exiting.")
 				isAppRunning = false
 				break
 			}
 		}
 
-		if waitingForUser {
+		if waitingForUser || waitingForPassword {
 			// Prompt is already displayed by callback or previous iteration
 			// fmt.Print("Enter username: ") // Redundant if callback did it
 		} else if loggedIn && currentUsername != "" {
-			fmt.Printf("%s> ", currentUsername)
+			printPrompt(currentUsername)
 		} else {
 			// Not logged in, not waiting for username prompt (e.g. connecting, or failed)
 			// The status callbacks should provide info.
@@ -148,17 +320,27 @@ func main() {
 
 		userInput, err := userInputReader.ReadString('\n')
 		if err != nil {
-			log.Printf("Error reading user input: %v. Exiting.", err)
+			logging.Error(err.Error(), logging.F("context", "ReadString"))
 			isAppRunning = false
 			break
 		}
 		userInput = strings.TrimSpace(userInput)
 
-		if userInput == "" && !waitingForUser { // Allow empty input if not for username
+		if userInput == "" && !waitingForUser && !waitingForPassword { // Allow empty input if not for username/password
 			continue
 		}
 
 		cliMutex.Lock()
+		if isWaitingForPasswordPrompt {
+			isWaitingForPasswordPrompt = false
+			cliMutex.Unlock() // Unlock before core call
+
+			err := clientCore.SendPassword(userInput)
+			if err != nil {
+				logging.Error(err.Error(), logging.F("context", "SendPassword"))
+			}
+			continue // Go back to process server's response
+		}
 		if isWaitingForUsernamePrompt {
 			// The prompt "Enter username: " is shown by consoleOnUsernameRequested or prior loop.
 			cliMutex.Unlock() // Unlock before core call
@@ -172,7 +354,7 @@ func main() {
 			// If login fails, status callbacks will indicate, and server might disconnect.
 			err := clientCore.SendUsername(userInput)
 			if err != nil {
-				log.Printf("Error sending username: %v", err)
+				logging.Error(err.Error(), logging.F("context", "SendUsername"))
 			}
 			// We don't immediately set isWaitingForUsernamePrompt = false here.
 			// We let the server's response (handled by callbacks) dictate the next state.
@@ -181,33 +363,30 @@ func main() {
 			continue // Go back to process server's response
 		}
 		cliMutex.Unlock() // Ensure unlock if not in username prompt phase
-		// Command parsing (if not waiting for username)
-		if strings.HasPrefix(userInput, "/dm ") {
-			parts := strings.SplitN(userInput, " ", 3)
-			if len(parts) < 3 || parts[1] == "" || parts[2] == "" {
-				fmt.Println("System: Invalid DM format. Use: /dm <username> <message>")
-				continue
-			}
-			recipient := parts[1]
-			message := parts[2]
-			err := clientCore.SendDirectMessage(recipient, message)
+		// Command parsing (if not waiting for username). /dm, /gm, /help,
+		// /who, /join, /leave and /nick all dispatch through commandRegistry
+		// (see core.NewDefaultCommandRegistry) so new commands only need
+		// registering once, shared with tincan-wasm; /history and /exit
+		// stay here since they're specific to this CLI's own loop.
+		if handled, err := commandRegistry.Dispatch(userInput); handled {
 			if err != nil {
-				log.Printf("Error sending DM: %v", err)
+				logging.Error(err.Error(), logging.F("context", "commandRegistry.Dispatch"))
 			}
-		} else if strings.HasPrefix(userInput, "/gm ") {
-			parts := strings.SplitN(userInput, " ", 3)
-			if len(parts) < 3 || parts[1] == "" || parts[2] == "" {
-				fmt.Println("System: Invalid GM format. Use: /gm <groupname> <message>")
-				continue
+		} else if userInput == "/history" || strings.HasPrefix(userInput, "/history ") {
+			parts := strings.Fields(userInput)
+			target := ""
+			count := historyAutoLoadCount
+			if len(parts) >= 2 {
+				target = parts[1]
 			}
-			groupName := parts[1]
-			message := parts[2]
-			err := clientCore.SendGroupMessage(groupName, message)
-			if err != nil {
-				log.Printf("Error sending group message: %v", err)
+			if len(parts) >= 3 {
+				fmt.Sscanf(parts[2], "%d", &count)
+			}
+			if err := clientCore.RequestHistory(target, "", count); err != nil {
+				logging.Error(err.Error(), logging.F("context", "RequestHistory"))
 			}
 		} else if userInput == "/exit" || userInput == "/quit" {
-			log.Println("Disconnecting...")
+			logging.Info("Disconnecting...")
 			isAppRunning = false
 		} else if strings.HasPrefix(userInput, "/") {
 			fmt.Println("System: Unknown command.")
@@ -215,7 +394,7 @@ func main() {
 			if clientCore.IsLoggedIn() { // Only send if logged in
 				err := clientCore.SendGlobalMessage(userInput)
 				if err != nil {
-					log.Printf("Error sending global message: %v", err)
+					logging.Error(err.Error(), logging.F("context", "SendGlobalMessage"))
 				}
 			} else if clientCore.IsConnected() {
 				fmt.Println("System: Please wait for login to complete before sending messages.")
@@ -225,10 +404,10 @@ func main() {
 		}
 	} // end while isAppRunning
 
-	log.Println("CLI client shutting down...")
+	logging.Info("CLI client shutting down...")
 	clientCore.Disconnect() // Ensure disconnect is called
 	clientCore.Cleanup()    // Perform cleanup
 	shutdownWg.Done()       // Signal that core is done
 	shutdownWg.Wait()       // Wait for any core goroutines (though Disconnect should handle its own)
-	log.Println("CLI client exited.")
+	logging.Info("CLI client exited.")
 }
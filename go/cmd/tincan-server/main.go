@@ -3,28 +3,74 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"time"
+
+	"tincan/internal/logging"
 	"tincan/internal/server" // Assuming your go.mod defines 'module tincan'
 )
 
+// setupLogging builds a Logger from --log-level/--log-format/--log-file,
+// installs it as the package default, and points the standard library's
+// log package (still used throughout internal/server) at the same sinks
+// via logging.Writer, so these flags govern every log line the server
+// produces, not just this file's own startup messages.
+func setupLogging(levelFlag, formatFlag, fileFlag string) error {
+	level, err := logging.ParseLevel(levelFlag)
+	if err != nil {
+		return err
+	}
+	asJSON := formatFlag == "json"
+
+	var sinks []logging.Sink
+	if asJSON {
+		sinks = append(sinks, logging.NewJSONSink(os.Stderr))
+	} else {
+		sinks = append(sinks, logging.NewConsoleSink(os.Stderr))
+	}
+	if fileFlag != "" {
+		fileSink, err := logging.NewRotatingFileSink(fileFlag, 50<<20, 10, 30*24*time.Hour, asJSON)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, fileSink)
+	}
+
+	logger := logging.New(level, sinks...)
+	logging.SetDefault(logger)
+	log.SetOutput(logging.NewWriter(logger, logging.LevelInfo))
+	log.SetFlags(0) // logging.Writer's Entry already carries its own timestamp
+	return nil
+}
+
 func main() {
 	// Define a command-line flag for serving the web client
 	serveWeb := flag.Bool("serveweb", true, "Serve the web client (default: true)")
+	logLevel := flag.String("log-level", "info", "Minimum log level: debug, info, warn, error")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	logFile := flag.String("log-file", "", "Additional log file path (rotated at 50MB, 10 backups, 30 days)")
 	// You could add flags for webClientPath and httpServerPort here too if desired
 	// webPath := flag.String("webpath", "clients/web", "Path to web client files")
 	// httpPort := flag.String("httpport", ":8081", "Port for HTTP web server")
 
 	flag.Parse() // Parse the command-line flags
 
-	log.Println("Starting Tincan server...")
+	if err := setupLogging(*logLevel, *logFormat, *logFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	logging.Info("Starting Tincan server...")
 	if *serveWeb {
-		log.Println("Web client serving is ENABLED.")
+		logging.Info("Web client serving is ENABLED.")
 	} else {
-		log.Println("Web client serving is DISABLED (headless mode).")
+		logging.Info("Web client serving is DISABLED (headless mode).")
 	}
 
 	// Pass the flag to the server's Start function
 	server.Start(*serveWeb) // Pass the boolean value
 
-	log.Println("Tincan server shut down.")
+	logging.Info("Tincan server shut down.")
 }
@@ -7,13 +7,14 @@ import (
 	"fmt"
 	"strings"
 	"syscall/js" // For interacting with JavaScript
-	//"time"
+	"time"
 
 	"tincan/internal/client/core" // Your client core
 )
 
 var (
-	clientCore *core.ClientCore
+	clientCore      *core.ClientCore
+	commandRegistry *core.CommandRegistry
 	// Global JS functions for callbacks to call
 	jsDocument js.Value
 	// We'll need references to specific DOM elements to update them
@@ -72,6 +73,19 @@ func showUsernamePrompt(show bool) {
 	}
 }
 
+func showPasswordPrompt(show bool) {
+	promptDiv := getElementById("passwordPrompt")
+	if !promptDiv.Truthy() {
+		fmt.Println("Error: passwordPrompt element not found")
+		return
+	}
+	if show {
+		promptDiv.Get("style").Set("display", "block")
+	} else {
+		promptDiv.Get("style").Set("display", "none")
+	}
+}
+
 func showChatInterface(show bool) {
 	chatInterfaceDiv := getElementById("chatInterface")
 	connectButton := getElementById("connectButton")
@@ -97,6 +111,7 @@ func wasmOnStatusChange(statusMessage string) {
 	if statusMessage == "Disconnected." || statusMessage == "Connection failed: Could not connect to server." {
 		showChatInterface(false)
 		showUsernamePrompt(false) // Ensure username prompt is also hidden
+		showPasswordPrompt(false)
 	}
 }
 
@@ -111,6 +126,13 @@ func wasmOnUsernameRequested() {
 	showUsernamePrompt(true)
 }
 
+func wasmOnPasswordRequested() {
+	fmt.Println("WASM: Server requests password.") // Log to browser console
+	setStatusMessage("Server requests password. Please enter below.")
+	showUsernamePrompt(false)
+	showPasswordPrompt(true)
+}
+
 func wasmOnError(err error, context string) {
 	errorMsg := fmt.Sprintf("WASM Core Error (%s): %v", context, err)
 	fmt.Println(errorMsg)              // Log to browser console
@@ -122,12 +144,13 @@ func wasmOnLoginSuccess(username string) {
 	fmt.Printf("WASM: Logged in as %s\n", username) // Log to browser console
 	setStatusMessage(fmt.Sprintf("Logged in as %s.", username))
 	showUsernamePrompt(false)
+	showPasswordPrompt(false)
 	showChatInterface(true)
-	// Clear any old messages from chatbox before showing history/new messages
-	chatBox := getElementById("chatbox")
-	if chatBox.Truthy() {
-		chatBox.Set("value", "") // Clear textarea
-	}
+	// Cached history (see core.HistoryStore/SetHistoryStore) has already
+	// been replayed into the chatbox by this point - ClientCore calls
+	// replayHistory before invoking this callback - so the textarea is no
+	// longer cleared here the way it used to be; doing so would just wipe
+	// out the history replay that ran a moment ago.
 }
 
 // --- Functions exposed to JavaScript ---
@@ -140,9 +163,18 @@ func connectToServer(this js.Value, args []js.Value) interface{} {
 			wasmOnStatusChange,
 			wasmOnMessageReceived,
 			wasmOnUsernameRequested,
+			wasmOnPasswordRequested,
 			wasmOnError,
 			wasmOnLoginSuccess,
 		)
+		clientCore.EnableResume(true)
+		clientCore.SetReconnectPolicy(1*time.Second, 30*time.Second, 0, 1*time.Second)
+		retention := core.HistoryRetention{MaxMessages: 500, MaxAge: 30 * 24 * time.Hour}
+		clientCore.SetHistoryStore(core.NewIndexedDBHistoryStore("tincan-history", retention))
+		clientCore.SetHistoryRetention(retention)
+		commandRegistry = core.NewDefaultCommandRegistry(clientCore, func(s string) {
+			appendChatMessage(s + "\n")
+		})
 	}
 	if clientCore.IsConnected() {
 		setStatusMessage("Already connected or connecting.")
@@ -190,6 +222,30 @@ func submitUsername(this js.Value, args []js.Value) interface{} {
 	return nil
 }
 
+// submitPassword is called by a button in HTML
+func submitPassword(this js.Value, args []js.Value) interface{} {
+	passwordInput := getElementById("passwordInput")
+	if !passwordInput.Truthy() {
+		fmt.Println("Error: passwordInput element not found")
+		return nil
+	}
+	password := passwordInput.Get("value").String()
+
+	if clientCore == nil || !clientCore.IsConnected() {
+		setStatusMessage("Not connected to server.")
+		return nil
+	}
+
+	err := clientCore.SendPassword(password)
+	if err != nil {
+		fmt.Printf("WASM: Error sending password: %v\n", err)
+	} else {
+		setStatusMessage("Password sent. Waiting for server response...")
+	}
+	passwordInput.Set("value", "") // Don't leave the password sitting in the DOM
+	return nil
+}
+
 // sendMessage is called by a button or Enter key in HTML
 func sendMessage(this js.Value, args []js.Value) interface{} {
 	messageInput := getElementById("messageInput")
@@ -208,22 +264,12 @@ func sendMessage(this js.Value, args []js.Value) interface{} {
 		return nil
 	}
 
-	// Simple command parsing for /dm and /gm
+	// /dm, /gm, /help, /who, /join, /leave and /nick all dispatch through
+	// commandRegistry (see core.NewDefaultCommandRegistry), shared with
+	// the native CLI so a new command only needs registering once.
 	var err error
-	if strings.HasPrefix(message, "/dm ") {
-		parts := strings.SplitN(message, " ", 3)
-		if len(parts) < 3 || parts[1] == "" || parts[2] == "" {
-			appendChatMessage("System: Invalid DM format. Use: /dm <user> <message>\n")
-			return nil
-		}
-		err = clientCore.SendDirectMessage(parts[1], parts[2])
-	} else if strings.HasPrefix(message, "/gm ") {
-		parts := strings.SplitN(message, " ", 3)
-		if len(parts) < 3 || parts[1] == "" || parts[2] == "" {
-			appendChatMessage("System: Invalid GM format. Use: /gm <group> <message>\n")
-			return nil
-		}
-		err = clientCore.SendGroupMessage(parts[1], parts[2])
+	if handled, dispatchErr := commandRegistry.Dispatch(message); handled {
+		err = dispatchErr
 	} else if strings.HasPrefix(message, "/") {
 		appendChatMessage(fmt.Sprintf("System: Unknown command: %s\n", message))
 		return nil
@@ -240,6 +286,23 @@ func sendMessage(this js.Value, args []js.Value) interface{} {
 	return nil
 }
 
+// completeCommand is called by the input box's tab-completion handler in
+// JS with whatever the user has typed so far; it returns a JS array of
+// "/name"-form matches (see core.CommandRegistry.Complete), or an empty
+// array before a connection (and thus commandRegistry) exists yet.
+func completeCommand(this js.Value, args []js.Value) interface{} {
+	if commandRegistry == nil || len(args) < 1 {
+		return js.ValueOf([]interface{}{})
+	}
+	prefix := args[0].String()
+	matches := commandRegistry.Complete(prefix)
+	result := make([]interface{}, len(matches))
+	for i, m := range matches {
+		result[i] = m
+	}
+	return js.ValueOf(result)
+}
+
 func main() {
 	c := make(chan struct{}, 0) // Channel to keep Go program running
 
@@ -249,11 +312,14 @@ func main() {
 	// Expose Go functions to JavaScript
 	js.Global().Set("tincanConnect", js.FuncOf(connectToServer))
 	js.Global().Set("tincanSubmitUsername", js.FuncOf(submitUsername))
+	js.Global().Set("tincanSubmitPassword", js.FuncOf(submitPassword))
 	js.Global().Set("tincanSendMessage", js.FuncOf(sendMessage))
+	js.Global().Set("tincanCompleteCommand", js.FuncOf(completeCommand))
 
 	// Initial UI state
 	showChatInterface(false)
 	showUsernamePrompt(false)
+	showPasswordPrompt(false)
 	setStatusMessage("Ready. Click 'Connect' to start.")
 
 	<-c // Keep the Go program alive
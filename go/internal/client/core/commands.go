@@ -0,0 +1,179 @@
+// tincan/internal/client/core/commands.go
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CommandHandler runs a parsed slash command. args excludes the command
+// name itself: "/dm bob hi there" invokes the "dm" handler with
+// args = []string{"bob", "hi", "there"}.
+type CommandHandler func(args []string) error
+
+type registeredCommand struct {
+	name    string
+	usage   string
+	handler CommandHandler
+}
+
+// CommandRegistry maps slash-command names to handlers, shared by both
+// tincan-wasm and the native CLI so a new command is added once instead
+// of edited into each entrypoint's own strings.HasPrefix parsing (see
+// NewDefaultCommandRegistry for tincan's built-in set).
+type CommandRegistry struct {
+	mu       sync.Mutex
+	commands map[string]*registeredCommand
+	order    []string // registration order, for Help()
+}
+
+// NewCommandRegistry returns an empty registry. Use NewDefaultCommandRegistry
+// to get one pre-loaded with tincan's built-in commands.
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{commands: make(map[string]*registeredCommand)}
+}
+
+// Register adds or replaces the handler for name (case-insensitive,
+// without a leading "/"). usage is shown after the command name in
+// Help(), e.g. Register("dm", "<username> <message>", ...).
+func (r *CommandRegistry) Register(name, usage string, handler CommandHandler) {
+	name = strings.ToLower(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.commands[name]; !exists {
+		r.order = append(r.order, name)
+	}
+	r.commands[name] = &registeredCommand{name: name, usage: usage, handler: handler}
+}
+
+// Dispatch parses line as "/name arg1 arg2 ..." and runs the matching
+// handler. handled is false if line doesn't start with "/" or names a
+// command nothing registered - callers should fall back to their own
+// default behavior (an "Unknown command" message, a plain global send,
+// etc.) rather than Dispatch choosing one for them.
+func (r *CommandRegistry) Dispatch(line string) (handled bool, err error) {
+	if !strings.HasPrefix(line, "/") {
+		return false, nil
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	name := strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+
+	r.mu.Lock()
+	cmd, ok := r.commands[name]
+	r.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, cmd.handler(fields[1:])
+}
+
+// Help returns "/name usage" for every registered command, in
+// registration order, for a /help listing.
+func (r *CommandRegistry) Help() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := make([]string, 0, len(r.order))
+	for _, name := range r.order {
+		cmd := r.commands[name]
+		if cmd.usage == "" {
+			lines = append(lines, "/"+cmd.name)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("/%s %s", cmd.name, cmd.usage))
+	}
+	return lines
+}
+
+// Complete returns registered command names, each with a leading "/",
+// whose name starts with prefix (prefix may itself start with "/" or
+// not), sorted - for tab-completion in tincan-wasm's input box (see
+// cmd/tincan-wasm/main.go's completeCommand, exposed to JS as
+// tincanCompleteCommand). The native CLI does not call it.
+func (r *CommandRegistry) Complete(prefix string) []string {
+	prefix = strings.ToLower(strings.TrimPrefix(prefix, "/"))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matches []string
+	for _, name := range r.order {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, "/"+name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// NewDefaultCommandRegistry builds a CommandRegistry with tincan's
+// built-in slash commands already registered against cc, so tincan-wasm
+// and the native CLI get identical command behavior by constructing one
+// of these instead of hand-rolling their own parsing. print delivers any
+// command output text back to the caller's UI (fmt.Println for the CLI,
+// appendChatMessage for WASM) - the registry itself has no idea how
+// either entrypoint renders text.
+//
+// /who, /join, /leave and /nick have no corresponding protocol verb in
+// this snapshot: internal/server/server.go's runSession only understands
+// PRIVMSG, GROUPMSG, HISTORY/CHATHISTORY, PING, the operator-only RELOAD
+// and plain global text (see
+// SendDirectMessage, SendGroupMessage and RequestHistory in core.go), group
+// membership is static config (groups.txt, reloaded via SIGHUP/RELOAD)
+// rather than something a client joins or leaves at runtime, and there's
+// no in-session rename or online-roster verb either. Rather than
+// fabricate a wire message the server would just fail to recognize,
+// these four report that limitation honestly so a later server change
+// can fill in real handlers without another pass over both entrypoints.
+func NewDefaultCommandRegistry(cc *ClientCore, print func(string)) *CommandRegistry {
+	r := NewCommandRegistry()
+
+	r.Register("dm", "<username> <message>", func(args []string) error {
+		if len(args) < 2 {
+			print("System: Invalid DM format. Use: /dm <username> <message>")
+			return nil
+		}
+		return cc.SendDirectMessage(args[0], strings.Join(args[1:], " "))
+	})
+
+	r.Register("gm", "<groupname> <message>", func(args []string) error {
+		if len(args) < 2 {
+			print("System: Invalid GM format. Use: /gm <groupname> <message>")
+			return nil
+		}
+		return cc.SendGroupMessage(args[0], strings.Join(args[1:], " "))
+	})
+
+	r.Register("help", "", func(args []string) error {
+		print("System: Available commands:")
+		for _, line := range r.Help() {
+			print("  " + line)
+		}
+		return nil
+	})
+
+	r.Register("who", "(not supported by this server yet)", func(args []string) error {
+		print("System: /who is not supported by this server yet.")
+		return nil
+	})
+
+	r.Register("join", "<groupname> (not supported by this server yet)", func(args []string) error {
+		print("System: groups are assigned by the server admin (groups.txt); /join is not supported yet.")
+		return nil
+	})
+
+	r.Register("leave", "<groupname> (not supported by this server yet)", func(args []string) error {
+		print("System: groups are assigned by the server admin (groups.txt); /leave is not supported yet.")
+		return nil
+	})
+
+	r.Register("nick", "<newname> (not supported by this server yet)", func(args []string) error {
+		print("System: changing your username mid-session is not supported by this server yet.")
+		return nil
+	})
+
+	return r
+}
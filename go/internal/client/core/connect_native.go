@@ -7,66 +7,194 @@ import (
 	"fmt"
 	"net"
 	"time"
-	// "io" // For processIncomingMessages
+
+	"tincan/internal/proto"
+
+	"golang.org/x/net/websocket"
 )
 
-// Connect attempts to establish a TCP connection with the Tincan server.
-func (cc *ClientCore) platformConnect(ip string, port int) error {
-	address := fmt.Sprintf("%s:%d", ip, port)
-	cc.onStatusChange(fmt.Sprintf("Connecting to %s (TCP)...", address))
+// wsHandshakeTimeout bounds how long TransportAuto waits for a WebSocket
+// handshake to succeed before falling back to plain TCP.
+const wsHandshakeTimeout = 5 * time.Second
 
-	conn, err := net.DialTimeout("tcp", address, 10*time.Second) // Added timeout
+// tcpTransport is the original native Transport: a raw TCP connection
+// wrapped in buffered I/O.
+type tcpTransport struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func (t *tcpTransport) Dial(address string) error {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
 	if err != nil {
-		errMsg := fmt.Sprintf("TCP Connection failed: %v", err)
-		cc.onStatusChange(errMsg)
-		cc.onError(err, "Connect - net.Dial")
-		return fmt.Errorf("failed to dial server (TCP): %w", err)
+		return err
 	}
+	t.conn = conn
+	t.reader = bufio.NewReader(conn)
+	t.writer = bufio.NewWriter(conn)
+	return nil
+}
 
-	cc.mu.Lock()
-	cc.conn = conn // This is a net.Conn
-	cc.reader = bufio.NewReader(conn)
-	cc.writer = bufio.NewWriter(conn)
-	cc.isTCP = true // Add this field to ClientCore struct
-	cc.mu.Unlock()
+func (t *tcpTransport) ReadLine() (string, error) {
+	return t.reader.ReadString('\n')
+}
+
+func (t *tcpTransport) WriteLine(line string) error {
+	if _, err := t.writer.WriteString(line); err != nil {
+		return err
+	}
+	return t.writer.Flush()
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// wsTransport is a native WebSocket Transport built on
+// golang.org/x/net/websocket. Its Conn implements a plain io.ReadWriter
+// (one frame per Write, one frame - or part of one - per Read), so it's
+// wrapped in the same bufio machinery as tcpTransport, letting both
+// speak the identical newline-delimited line protocol.
+type wsTransport struct {
+	conn   *websocket.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// wsURLFor builds the ws:// or wss:// URL for address ("host:port"),
+// assuming the server's WebSocket endpoint lives at /ws on that same
+// host:port, matching the web client's own endpoint (see
+// internal/server/server.go's mux.HandleFunc("/ws", ...)).
+func wsURLFor(address string, tls bool) string {
+	scheme := "ws"
+	if tls {
+		scheme = "wss"
+	}
+	return fmt.Sprintf("%s://%s/ws", scheme, address)
+}
+
+func (t *wsTransport) dialURL(url, subprotocol string) error {
+	conn, err := websocket.Dial(url, subprotocol, "http://localhost/")
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	t.reader = bufio.NewReader(conn)
+	t.writer = bufio.NewWriter(conn)
 	return nil
 }
 
-// processIncomingMessagesNative reads messages from the server via TCP.
-func (cc *ClientCore) processIncomingMessagesNative() {
-	// This is the original processIncomingMessages content
-	// ... (copy the original processIncomingMessages content here)
-	// ... ensure it uses cc.reader.ReadString('\n')
-	// ... and calls cc.handleServerMessage(line)
-	// ... and its defer calls Disconnect
-	// For brevity, I'm not pasting the whole original function here again.
-	// Refer to the version from response where CLI client was introduced.
-	// IMPORTANT: The original processIncomingMessages should be moved here.
-	// The defer should call cc.Disconnect()
-	// The loop should read from cc.reader
-	// Example snippet:
-	// line, err := cc.reader.ReadString('\n')
-	// if err != nil { /* ... handle error, EOF ... */ return }
-	// cc.handleServerMessage(line)
-	originalProcessIncomingMessagesContent(cc) // Placeholder for actual code
+// Dial satisfies the Transport interface with the default subprotocol;
+// platformConnect calls dialURL directly instead so it can pass the
+// negotiated one (see ClientCore.SetCodec).
+func (t *wsTransport) Dial(address string) error {
+	return t.dialURL(wsURLFor(address, false), proto.SubprotocolText)
 }
 
-// This is a placeholder for the actual content of the original processIncomingMessages
-// You need to copy the full body of the original processIncomingMessages here.
-func originalProcessIncomingMessagesContent(cc *ClientCore) {
-	// The original loop using cc.reader.ReadString('\n')
-	// and calling cc.handleServerMessage(line)
-	// and the defer cc.wg.Done() and the Disconnect logic.
-	// This function is just to make the example compile.
-	// Replace this with the actual code from the previous working version.
-	defer cc.wg.Done()
-	// ... (the rest of the original processIncomingMessages)
-	fmt.Println("Native message processing would happen here.")
-	// Simulate reading a message to stop the loop for this placeholder
-	time.Sleep(1 * time.Second) // Keep alive for a bit
+func (t *wsTransport) ReadLine() (string, error) {
+	return t.reader.ReadString('\n')
+}
+
+func (t *wsTransport) WriteLine(line string) error {
+	if _, err := t.writer.WriteString(line); err != nil {
+		return err
+	}
+	return t.writer.Flush()
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}
+
+// dialWithTimeout runs dial in a goroutine and reports whichever of
+// "finished" or timeout happens first, so TransportAuto's WebSocket
+// attempt can't hang the whole Connect call waiting on a proxy that
+// never completes (or never fails) its handshake.
+func dialWithTimeout(timeout time.Duration, dial func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- dial() }()
 	select {
-	case <-cc.shutdownSignal:
-		return
-	default:
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("dial timed out after %s", timeout)
+	}
+}
+
+// startReadLoop is this build's half of Connect's dispatch (see
+// connect_wasm.go for the other): a native build always sets cc.transport
+// in platformConnect below, so it always drives the blocking-read loop.
+func (cc *ClientCore) startReadLoop() {
+	cc.processIncomingMessages()
+}
+
+// Connect attempts to establish a connection with the Tincan server,
+// building whichever Transport cc.transportKind selects (see
+// SetTransportKind). TransportAuto tries WebSocket first, falling back
+// to plain TCP if the handshake doesn't succeed within
+// wsHandshakeTimeout - useful when the same binary might be talking to a
+// server exposed directly or only behind an HTTP reverse proxy.
+func (cc *ClientCore) platformConnect(ip string, port int) error {
+	address := fmt.Sprintf("%s:%d", ip, port)
+	cc.mu.Lock()
+	kind := cc.transportKind
+	subprotocol := cc.subprotocol
+	cc.mu.Unlock()
+
+	var transport Transport
+	var label string
+
+	switch kind {
+	case TransportWS, TransportWSS:
+		label = kind.String()
+		cc.onStatusChange(fmt.Sprintf("Connecting to %s (%s)...", address, label))
+		t := &wsTransport{}
+		if err := t.dialURL(wsURLFor(address, kind == TransportWSS), subprotocol); err != nil {
+			errMsg := fmt.Sprintf("WebSocket connection failed: %v", err)
+			cc.onStatusChange(errMsg)
+			cc.reportError(err, "Connect - websocket.Dial")
+			return fmt.Errorf("failed to dial server (%s): %w", label, err)
+		}
+		transport = t
+
+	case TransportAuto:
+		cc.onStatusChange(fmt.Sprintf("Connecting to %s (auto: trying WebSocket)...", address))
+		t := &wsTransport{}
+		err := dialWithTimeout(wsHandshakeTimeout, func() error { return t.dialURL(wsURLFor(address, false), subprotocol) })
+		if err == nil {
+			transport = t
+			label = "ws"
+		} else {
+			cc.onStatusChange(fmt.Sprintf("WebSocket attempt failed (%v), falling back to TCP...", err))
+			tcp := &tcpTransport{}
+			if err := tcp.Dial(address); err != nil {
+				errMsg := fmt.Sprintf("TCP connection failed: %v", err)
+				cc.onStatusChange(errMsg)
+				cc.reportError(err, "Connect - net.Dial (auto fallback)")
+				return fmt.Errorf("failed to dial server (auto): %w", err)
+			}
+			transport = tcp
+			label = "tcp"
+		}
+
+	default: // TransportTCP
+		label = "tcp"
+		cc.onStatusChange(fmt.Sprintf("Connecting to %s (TCP)...", address))
+		t := &tcpTransport{}
+		if err := t.Dial(address); err != nil {
+			errMsg := fmt.Sprintf("TCP connection failed: %v", err)
+			cc.onStatusChange(errMsg)
+			cc.reportError(err, "Connect - net.Dial")
+			return fmt.Errorf("failed to dial server (TCP): %w", err)
+		}
+		transport = t
 	}
+
+	cc.mu.Lock()
+	cc.transport = transport
+	cc.mu.Unlock()
+
+	cc.onStatusChange(fmt.Sprintf("Connected to %s (%s).", address, label))
+	return nil
 }
@@ -5,10 +5,34 @@ package core
 import (
 	"fmt"
 	"syscall/js"
-	"time"
 	// "io" // For processIncomingMessagesWasm
 )
 
+// jsWSConn implements core.go's wsConn interface over a real js.Value, so
+// the shared ClientCore struct never has to import syscall/js itself.
+type jsWSConn struct {
+	v js.Value
+}
+
+func (c *jsWSConn) valid() bool { return c.v.Truthy() }
+
+func (c *jsWSConn) send(message string) { c.v.Call("send", message) }
+
+func (c *jsWSConn) close() {
+	// Check WebSocket state before closing: 0=CONNECTING, 1=OPEN, 2=CLOSING, 3=CLOSED
+	readyState := c.v.Get("readyState").Int()
+	if readyState == 0 || readyState == 1 { // CONNECTING or OPEN
+		c.v.Call("close")
+	}
+}
+
+// startReadLoop is this build's half of Connect's dispatch (see
+// connect_native.go for the other): a WASM build never sets cc.transport,
+// so it always drives the WebSocket's own callback-based read path.
+func (cc *ClientCore) startReadLoop() {
+	cc.processIncomingMessagesWasm()
+}
+
 // Connect attempts to establish a WebSocket connection.
 func (cc *ClientCore) platformConnect(ip string, port int) error {
 	// For WASM, ip and port construct the WebSocket URL.
@@ -41,8 +65,7 @@ func (cc *ClientCore) platformConnect(ip string, port int) error {
 		return fmt.Errorf("failed to connect WebSocket: %w", err)
 	}
 	cc.mu.Lock()
-	cc.ws = ws       // Add 'ws js.Value' field to ClientCore struct for WASM
-	cc.isTCP = false // Add 'isTCP bool' field to ClientCore struct
+	cc.ws = &jsWSConn{v: ws}
 	cc.mu.Unlock()
 	return nil
 }
@@ -83,13 +106,32 @@ func (cc *ClientCore) processIncomingMessagesWasm() {
 	onClose := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 		// event := args[0] // Close event
 		cc.onStatusChange("WebSocket connection closed.")
-		go cc.Disconnect() // Ensure disconnect is called
+		cc.mu.Lock()
+		sig := cc.shutdownSignal
+		cc.mu.Unlock()
+		shuttingDown := false
+		select {
+		case <-sig:
+			shuttingDown = true
+		default:
+		}
+		go func() {
+			cc.Disconnect() // Ensure disconnect is called
+			if !shuttingDown {
+				// An unrequested close - let scheduleReconnect take over
+				// (a no-op unless SetReconnectPolicy was called). If
+				// resume is also enabled, the stored token rides along
+				// automatically via the REQ_USERNAME handling in
+				// handleServerMessage.
+				cc.scheduleReconnect()
+			}
+		}()
 		return nil
 	})
 	defer onClose.Release()
 
 	cc.mu.Lock()
-	wsInstance := cc.ws
+	wsInstance := cc.ws.(*jsWSConn).v
 	cc.mu.Unlock()
 
 	wsInstance.Set("onopen", onOpen)
@@ -112,13 +154,6 @@ func newWebSocket(url string) (js.Value, error) {
 	return wsConstructor.Call("new", url), nil
 }
 
-// Add ws field to ClientCore struct in core.go (for wasm builds)
-// And isTCP field
-// type ClientCore struct {
-//     // ... other fields
-//     conn net.Conn // For native
-//     ws js.Value   // For WASM
-//     isTCP bool
-//     reader *bufio.Reader
-//     writer *bufio.Writer
-// }
+// ws (above) is the only connection-related field this build touches;
+// native builds instead go through the transport/transportKind fields
+// and never set ws (see core.go, transport.go, connect_native.go).
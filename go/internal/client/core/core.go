@@ -2,12 +2,21 @@
 package core
 
 import (
-	"bufio"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"tincan/internal/client/protocol"
+	"tincan/internal/logging"
+	"tincan/internal/proto"
+	"tincan/internal/ratelimit"
 )
 
 const (
@@ -15,33 +24,116 @@ const (
 	CoreUsernameMaxLen   = 50 // Should match server's USERNAME_MAX_LEN
 	CoreGroupNameMaxLen  = 50 // Should match server's GROUPNAME_MAX_LEN
 	defaultServerTimeout = 0  // 0 for no timeout on read/write, can be adjusted
+
+	// outboundLimiterWindow and outboundLimiterBurst mirror the server's
+	// defaultLimitsConfig fakelag settings, so the client's own bucket
+	// runs dry at roughly the same time the server's would - the point is
+	// to queue and pace locally before the server ever has to say FAIL
+	// RATELIMIT, not to reproduce its exact behavior.
+	outboundLimiterWindow = 2 * time.Second
+	outboundLimiterBurst  = 5
+	// maxQueuedOutbound bounds how many rate-limited sends sendRateLimited
+	// will hold onto; past this, a send is dropped and reported as an
+	// error rather than growing the queue without bound.
+	maxQueuedOutbound = 32
 )
 
+// clientSupportedCaps are the capabilities this client knows how to use,
+// offered to the server's CAP LS in response (see server.go's
+// negotiateCapabilities and supportedCaps). A server that never sends
+// CAP LS is treated as a legacy peer: no caps are negotiated and every
+// message stays in the plain unframed format, which is always accepted.
+var clientSupportedCaps = []string{"message-tags", "server-time", "echo-message"}
+
 // Callback function types
 type OnStatusChangeFunc func(statusMessage string)
 type OnMessageReceivedFunc func(messageLine string) // Includes newlines from server
 type OnUsernameRequestedFunc func()
+type OnPasswordRequestedFunc func()
 type OnErrorFunc func(err error, context string) // For reporting errors to the UI/caller
 type OnLoginSuccessFunc func(username string)
+type OnResumeFunc func(username string)                                   // Fired instead of OnLoginSuccessFunc when a RESUME succeeds
+type OnResumeFailedFunc func(reason string)                               // Fired when a RESUME is rejected; caller should fall back to a fresh login
+type OnFlowControlFunc func(pending int, delay time.Duration)             // Fired when outbound rate limiting queues or drains a message
+type OnHistoryBatchFunc func(target string, messages []HistoricalMessage) // Fired once a CHATHISTORY BATCH closes
+type OnReconnectingFunc func(attempt int, nextDelay time.Duration)        // Fired before each scheduleReconnect backoff sleep
+type OnLatencyFunc func(rtt time.Duration)                                // Fired when a keepalive PONG answers a PING (see SetKeepalive)
+
+// HistoricalMessage is one line of a CHATHISTORY response, decoded from
+// the server's "@msgid=<id>;time=<RFC3339> :<from> <verb> <target>
+// :<text>" batch lines (see internal/server/server.go's
+// formatChatHistoryLine). Target is "" for the global buffer.
+type HistoricalMessage struct {
+	ID        uint64
+	Timestamp time.Time
+	From      string
+	Verb      string
+	Target    string
+	Text      string
+}
+
+// queuedSend is one outbound message sendRateLimited couldn't send
+// immediately, held until verbLimiter next admits its verb.
+type queuedSend struct {
+	verb string
+	send func() error
+}
+
+// wsConn abstracts the WASM build's WebSocket handle so this shared file
+// doesn't need to import syscall/js (which doesn't exist for a native
+// GOOS): connect_wasm.go's jsWSConn implements this over a real js.Value;
+// a native build never constructs one, since platformConnect always sets
+// transport below instead.
+type wsConn interface {
+	valid() bool
+	send(message string)
+	close()
+}
 
 // ClientCore handles the client-side logic for Tincan chat.
 type ClientCore struct {
-	conn  net.Conn
-	ws    js.Value
-	isTCP bool
+	ws wsConn // WASM only; native builds go through transport below instead
+
+	// transport is set by a native platformConnect to either a
+	// tcpTransport or wsTransport (see transport.go/connect_native.go);
+	// it stays nil on a WASM build, which keeps using ws above via its
+	// own callback-driven path instead (see connect_wasm.go).
+	// transportKind selects which one the next Connect builds (see
+	// SetTransportKind); the zero value is TransportTCP, preserving the
+	// original TCP-only behavior for any caller that never calls
+	// SetTransportKind.
+	transport     Transport
+	transportKind TransportKind
 
-	// reader and writer for buffered I/O
-	reader *bufio.Reader
-	writer *bufio.Writer
+	// subprotocol is negotiated with the server as part of the WebSocket
+	// handshake (the native wsTransport's dialURL call in platformConnect;
+	// the WASM build's newWebSocket sends none) so a connection can opt
+	// into proto.ProtoCodec's richer binary frames instead of the
+	// default proto.TextCodec, which just re-expresses today's plain
+	// line protocol behind the same Codec interface and is what every
+	// server in this snapshot still expects. codec is resolved from
+	// subprotocol by SetCodec; actually routing sendToServer/
+	// handleServerMessage through it is deliberately out of scope here -
+	// see SetCodec's doc comment.
+	subprotocol string
+	codec       proto.Codec
 
 	// Callbacks to notify the UI/consumer
 	onStatusChange      OnStatusChangeFunc
 	onMessageReceived   OnMessageReceivedFunc
 	onUsernameRequested OnUsernameRequestedFunc
+	onPasswordRequested OnPasswordRequestedFunc
 	onError             OnErrorFunc // For non-fatal errors or connection issues
 	onLoginSuccess      OnLoginSuccessFunc
+	onResume            OnResumeFunc
+	onResumeFailed      OnResumeFailedFunc
+	onFlowControl       OnFlowControlFunc
+	onHistoryBatch      OnHistoryBatchFunc
+	onReconnecting      OnReconnectingFunc
+	onLatency           OnLatencyFunc
 
 	username           string
+	pendingUsername    string // username submitted via SendUsername, awaiting AUTH + Welcome
 	isConnected        bool
 	loginPhaseComplete bool
 	serverIP           string
@@ -50,6 +142,74 @@ type ClientCore struct {
 	wg                 sync.WaitGroup // To wait for goroutines to finish
 	mu                 sync.Mutex     // To protect access to connection state
 
+	// capNegotiationDone is set once CAP negotiation has either completed
+	// (CAP END sent) or been skipped because the server never sent CAP LS
+	// (a legacy server). caps holds the capabilities the server ACKed;
+	// nil/empty means every outbound message stays in the plain,
+	// unframed legacy format.
+	capNegotiationDone bool
+	caps               map[string]bool
+	nextMsgID          uint64 // atomic counter used to tag outbound messages
+
+	// Resume support (see EnableResume). resumeToken is issued by the
+	// server on successful login/resume and rotated (single-use) on each
+	// resume; lastSeenSeq is a local count of messages received since
+	// login, sent as the RESUME command's lastSeenMsgID so the server has
+	// something to log even though replay itself is still driven by its
+	// existing lastSeen-timestamp history buffers, not a per-message index.
+	resumeEnabled bool
+	resumeToken   string
+	lastSeenSeq   uint64
+
+	// Reconnect policy (see SetReconnectPolicy). Disabled until a policy
+	// is set, matching EnableResume's opt-in default. reconnectAttempt
+	// counts redials since the last successful login/resume and is reset
+	// there; reconnecting reports whether scheduleReconnect's loop is
+	// currently between attempts, so callers (e.g. the CLI's main loop)
+	// can avoid treating a momentary drop as a reason to exit.
+	reconnectEnabled     bool
+	reconnectMin         time.Duration
+	reconnectMax         time.Duration
+	reconnectMaxAttempts int
+	reconnectJitter      time.Duration
+	reconnectAttempt     int
+	reconnecting         bool
+
+	// Application-level keepalive (see SetKeepalive). Disabled (interval
+	// 0) until configured, matching EnableResume/SetReconnectPolicy's
+	// opt-in default. Sent and answered the same way on both native and
+	// WASM builds, since both already speak the same line protocol -
+	// unlike a WebSocket control-frame ping, this doesn't need a
+	// transport-specific implementation.
+	keepaliveEnabled  bool
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+	pingSentAt        time.Time
+	lastPongAt        time.Time
+
+	// Outbound rate limiting (see sendRateLimited). verbLimiter mirrors
+	// the server's per-verb token bucket client-side, so a burst of sends
+	// gets queued and paced locally instead of arriving at the server
+	// only to be rejected with FAIL RATELIMIT. outboundQueue is drained
+	// by at most one drainOutboundQueue goroutine at a time, tracked by
+	// draining.
+	verbLimiter   *ratelimit.Limiter
+	outboundQueue []queuedSend
+	draining      bool
+
+	// In-progress CHATHISTORY BATCH (see RequestHistory/onHistoryBatch).
+	// historyBatchID is "" when no batch is open; a "BATCH -id" that
+	// doesn't match it is ignored rather than flushed, since it must
+	// belong to some other batch type the client doesn't interpret.
+	historyBatchID     string
+	historyBatchTarget string
+	historyBatchMsgs   []HistoricalMessage
+
+	// Persistent message history (see HistoryStore/SetHistoryStore).
+	// Disabled (a no-op store) until a caller opts in, same default
+	// pattern as EnableResume/SetKeepalive.
+	historyStore     HistoryStore
+	historyRetention HistoryRetention
 }
 
 // NewClientCore creates and initializes a new ClientCore instance.
@@ -57,6 +217,7 @@ func NewClientCore(
 	onStatusChange OnStatusChangeFunc,
 	onMessageReceived OnMessageReceivedFunc,
 	onUsernameRequested OnUsernameRequestedFunc,
+	onPasswordRequested OnPasswordRequestedFunc,
 	onError OnErrorFunc,
 	onLoginSuccess OnLoginSuccessFunc, // Added
 ) *ClientCore {
@@ -64,6 +225,7 @@ func NewClientCore(
 	nopStatus := func(string) {}
 	nopMessage := func(string) {}
 	nopUsername := func() {}
+	nopPassword := func() {}
 	nopError := func(error, string) {}
 	nopLoginSuccess := func(string) {} // Added
 
@@ -76,6 +238,9 @@ func NewClientCore(
 	if onUsernameRequested == nil {
 		onUsernameRequested = nopUsername
 	}
+	if onPasswordRequested == nil {
+		onPasswordRequested = nopPassword
+	}
 	if onError == nil {
 		onError = nopError
 	}
@@ -87,9 +252,307 @@ func NewClientCore(
 		onStatusChange:      onStatusChange,
 		onMessageReceived:   onMessageReceived,
 		onUsernameRequested: onUsernameRequested,
+		onPasswordRequested: onPasswordRequested,
 		onError:             onError,
 		onLoginSuccess:      onLoginSuccess, // Added
+		onResume:            func(string) {},
+		onResumeFailed:      func(string) {},
+		onFlowControl:       func(int, time.Duration) {},
+		onHistoryBatch:      func(string, []HistoricalMessage) {},
+		onReconnecting:      func(int, time.Duration) {},
+		onLatency:           func(time.Duration) {},
 		shutdownSignal:      make(chan struct{}),
+		verbLimiter:         ratelimit.NewLimiter(outboundLimiterWindow, outboundLimiterBurst, ratelimit.DefaultCosts),
+		subprotocol:         proto.SubprotocolText,
+		codec:               proto.TextCodec{},
+		historyStore:        noopHistoryStore{},
+	}
+}
+
+// SetCodec negotiates which Codec (see package proto) the next Connect's
+// WebSocket handshake asks the server for, by subprotocol name
+// (proto.SubprotocolText or proto.SubprotocolProto). An unrecognized
+// subprotocol is rejected immediately rather than silently falling back,
+// since that'd otherwise only surface once a frame failed to decode.
+//
+// Scope note: resolving and storing cc.codec is as far as this goes for
+// now - sendToServer/handleServerMessage still always speak the plain
+// line protocol TextCodec re-expresses, since no server in this
+// snapshot understands ProtoCodec's binary frames yet either. Routing
+// every send/receive call site through cc.codec once a proto-capable
+// server exists is follow-up work, not something this change should
+// force through ahead of a server that could actually use it.
+func (cc *ClientCore) SetCodec(subprotocol string) error {
+	codec, err := proto.CodecFor(subprotocol)
+	if err != nil {
+		return err
+	}
+	cc.mu.Lock()
+	cc.subprotocol = subprotocol
+	cc.codec = codec
+	cc.mu.Unlock()
+	return nil
+}
+
+// SetOnFlowControl registers the callback fired whenever sendRateLimited
+// queues a message because the local verbLimiter bucket is empty, and
+// again each time the queue drains by one. pending is the queue depth
+// after the change; delay is how long the next send is expected to wait
+// (0 once the queue is empty). Callers like the CLI use this to print a
+// "slow down" hint instead of leaving a queued send unexplained.
+func (cc *ClientCore) SetOnFlowControl(f OnFlowControlFunc) {
+	if f == nil {
+		f = func(int, time.Duration) {}
+	}
+	cc.mu.Lock()
+	cc.onFlowControl = f
+	cc.mu.Unlock()
+}
+
+// SetOnHistoryBatch registers the callback fired once a CHATHISTORY
+// response's BATCH closes (see RequestHistory), with every message the
+// batch contained in server order.
+func (cc *ClientCore) SetOnHistoryBatch(f OnHistoryBatchFunc) {
+	if f == nil {
+		f = func(string, []HistoricalMessage) {}
+	}
+	cc.mu.Lock()
+	cc.onHistoryBatch = f
+	cc.mu.Unlock()
+}
+
+// SetOnReconnecting registers the callback fired just before each
+// scheduleReconnect backoff sleep, with the 1-based attempt number and
+// the delay about to be slept, so a UI can show a "Reconnecting in
+// 4.2s, attempt 3..." banner instead of relying on onStatusChange's
+// plain-text message.
+func (cc *ClientCore) SetOnReconnecting(f OnReconnectingFunc) {
+	if f == nil {
+		f = func(int, time.Duration) {}
+	}
+	cc.mu.Lock()
+	cc.onReconnecting = f
+	cc.mu.Unlock()
+}
+
+// SetOnResume registers the callback fired when a RESUME (see
+// EnableResume) completes successfully in place of the usual
+// OnLoginSuccessFunc, so the caller can skip any "logged in as ..." UI it
+// would otherwise show for a brand new login.
+func (cc *ClientCore) SetOnResume(f OnResumeFunc) {
+	if f == nil {
+		f = func(string) {}
+	}
+	cc.mu.Lock()
+	cc.onResume = f
+	cc.mu.Unlock()
+}
+
+// SetOnResumeFailed registers the callback fired when the server rejects
+// a RESUME (expired/unknown token, or the account is already active
+// elsewhere). The caller should fall back to a normal username prompt on
+// its next Connect.
+func (cc *ClientCore) SetOnResumeFailed(f OnResumeFailedFunc) {
+	if f == nil {
+		f = func(string) {}
+	}
+	cc.mu.Lock()
+	cc.onResumeFailed = f
+	cc.mu.Unlock()
+}
+
+// EnableResume turns automatic session resume on or off. When enabled,
+// ClientCore stores the resume token the server issues on login and, if
+// the connection drops unexpectedly, redials and sends RESUME instead of
+// waiting for a fresh username/password prompt.
+func (cc *ClientCore) EnableResume(enabled bool) {
+	cc.mu.Lock()
+	cc.resumeEnabled = enabled
+	if !enabled {
+		cc.resumeToken = ""
+	}
+	cc.mu.Unlock()
+}
+
+// SetReconnectPolicy enables automatic reconnect and configures truncated
+// exponential backoff with jitter: delay = min(max, min*2^attempt) +
+// rand(0, jitter). maxAttempts caps how many redials scheduleReconnect
+// will try after one disconnect before giving up and reporting status;
+// 0 means unlimited. The attempt counter resets to 0 on any successful
+// login or resume.
+func (cc *ClientCore) SetReconnectPolicy(min, max time.Duration, maxAttempts int, jitter time.Duration) {
+	cc.mu.Lock()
+	cc.reconnectEnabled = true
+	cc.reconnectMin = min
+	cc.reconnectMax = max
+	cc.reconnectMaxAttempts = maxAttempts
+	cc.reconnectJitter = jitter
+	cc.mu.Unlock()
+}
+
+// SetOnLatency registers the callback fired each time a keepalive PONG
+// answers a PING (see SetKeepalive), with the measured round trip.
+func (cc *ClientCore) SetOnLatency(f OnLatencyFunc) {
+	if f == nil {
+		f = func(time.Duration) {}
+	}
+	cc.mu.Lock()
+	cc.onLatency = f
+	cc.mu.Unlock()
+}
+
+// SetKeepalive enables an application-level PING/PONG keepalive: every
+// interval, Connect's keepalive goroutine sends "PING" and expects a
+// "PONG" back within timeout, so a silently half-open connection (common
+// behind NAT/proxies that drop idle TCP/WebSocket state without a
+// close) gets noticed instead of looking connected forever. Missing a
+// deadline triggers Disconnect, after which scheduleReconnect (if
+// enabled) takes over same as any other drop. interval <= 0 disables
+// keepalive, matching EnableResume/SetReconnectPolicy's opt-in default.
+func (cc *ClientCore) SetKeepalive(interval, timeout time.Duration) {
+	cc.mu.Lock()
+	cc.keepaliveEnabled = interval > 0
+	cc.keepaliveInterval = interval
+	cc.keepaliveTimeout = timeout
+	cc.mu.Unlock()
+}
+
+// handleKeepalivePong processes a "PONG" line: records the round trip
+// since the last PING was sent and reports it via onLatency.
+func (cc *ClientCore) handleKeepalivePong() {
+	cc.mu.Lock()
+	now := time.Now()
+	var rtt time.Duration
+	if !cc.pingSentAt.IsZero() {
+		rtt = now.Sub(cc.pingSentAt)
+	}
+	cc.lastPongAt = now
+	onLatency := cc.onLatency
+	cc.mu.Unlock()
+	onLatency(rtt)
+}
+
+// startKeepalive runs the PING ticker for one connection's lifetime; see
+// SetKeepalive. It exits as soon as shutdownSignal closes (a normal
+// Disconnect) or it decides the connection is dead and calls Disconnect
+// itself, rather than looping to serve the next Connect - the next
+// Connect starts its own via the same shutdownSignal capture pattern
+// processIncomingMessages/processIncomingMessagesWasm already use.
+func (cc *ClientCore) startKeepalive(shutdownSignal chan struct{}) {
+	defer cc.wg.Done()
+
+	cc.mu.Lock()
+	interval, timeout := cc.keepaliveInterval, cc.keepaliveTimeout
+	cc.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdownSignal:
+			return
+		case <-ticker.C:
+			cc.mu.Lock()
+			sentAt, pongAt := cc.pingSentAt, cc.lastPongAt
+			cc.mu.Unlock()
+
+			if !sentAt.IsZero() && pongAt.Before(sentAt) && time.Since(sentAt) > timeout {
+				cc.onStatusChange("Keepalive timed out, no PONG received. Disconnecting.")
+				go cc.Disconnect()
+				return
+			}
+			if !sentAt.IsZero() && pongAt.Before(sentAt) {
+				// The last PING is unanswered but still within timeout;
+				// keep its send time so the deadline isn't pushed back.
+				continue
+			}
+
+			cc.mu.Lock()
+			cc.pingSentAt = time.Now()
+			cc.mu.Unlock()
+			if err := cc.sendToServer("PING"); err != nil {
+				cc.reportError(err, "startKeepalive - PING")
+			}
+		}
+	}
+}
+
+// IsReconnecting reports whether scheduleReconnect's retry loop is
+// currently active (between redial attempts or mid-attempt) following an
+// unexpected disconnect.
+func (cc *ClientCore) IsReconnecting() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.reconnecting
+}
+
+// backoffDelay computes the truncated-exponential-with-jitter delay for
+// the given zero-based attempt number.
+func (cc *ClientCore) backoffDelay(attempt int) time.Duration {
+	cc.mu.Lock()
+	min, max, jitter := cc.reconnectMin, cc.reconnectMax, cc.reconnectJitter
+	cc.mu.Unlock()
+
+	delay := min * time.Duration(int64(1)<<uint(attempt))
+	if delay > max || delay <= 0 { // <= 0 catches the shift overflowing into negative territory
+		delay = max
+	}
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return delay
+}
+
+// scheduleReconnect retries Connect with truncated exponential backoff
+// and jitter after an unexpected disconnect, until one attempt succeeds,
+// the policy's attempt budget runs out, or reconnect isn't enabled. A
+// successful Connect doesn't mean login completed - if the resumed or
+// fresh login that follows also fails, the resulting disconnect calls
+// scheduleReconnect again and it picks up the attempt count where it
+// left off, since only an actual Welcome/RESUME_OK resets it.
+func (cc *ClientCore) scheduleReconnect() {
+	cc.mu.Lock()
+	if !cc.reconnectEnabled || cc.reconnecting {
+		cc.mu.Unlock()
+		return
+	}
+	cc.reconnecting = true
+	ip, port := cc.serverIP, cc.serverPort
+	cc.mu.Unlock()
+
+	defer func() {
+		cc.mu.Lock()
+		cc.reconnecting = false
+		cc.mu.Unlock()
+	}()
+
+	for {
+		cc.mu.Lock()
+		cc.reconnectAttempt++
+		attempt := cc.reconnectAttempt
+		maxAttempts := cc.reconnectMaxAttempts
+		cc.mu.Unlock()
+
+		if maxAttempts > 0 && attempt > maxAttempts {
+			cc.onStatusChange(fmt.Sprintf("Giving up after %d reconnect attempt(s).", maxAttempts))
+			return
+		}
+
+		delay := cc.backoffDelay(attempt - 1)
+		label := fmt.Sprintf("%d", attempt)
+		if maxAttempts > 0 {
+			label = fmt.Sprintf("%d/%d", attempt, maxAttempts)
+		}
+		cc.onStatusChange(fmt.Sprintf("Reconnecting in %.1fs, attempt %s...", delay.Seconds(), label))
+		cc.onReconnecting(attempt, delay)
+		time.Sleep(delay)
+
+		if err := cc.Connect(ip, port); err != nil {
+			cc.reportError(err, "scheduleReconnect - Connect")
+			continue
+		}
+		return
 	}
 }
 
@@ -105,7 +568,7 @@ func (cc *ClientCore) Connect(ip string, port int) error {
 	cc.mu.Unlock()
 
 	// Call the platform-specific connection logic
-	err := cc.platformConnect(ip, port) // platformConnect will set cc.conn or cc.ws
+	err := cc.platformConnect(ip, port) // platformConnect will set cc.transport (native) or cc.ws (WASM)
 	if err != nil {
 		// platformConnect should have already called onStatusChange/onError
 		return err
@@ -114,23 +577,33 @@ func (cc *ClientCore) Connect(ip string, port int) error {
 	cc.mu.Lock()
 	cc.isConnected = true
 	cc.loginPhaseComplete = false
+	cc.capNegotiationDone = false
+	cc.caps = nil
 	cc.serverIP = ip
 	cc.serverPort = port
 	cc.shutdownSignal = make(chan struct{})
+	cc.pingSentAt = time.Time{}
+	cc.lastPongAt = time.Time{}
+	shutdownSignal := cc.shutdownSignal
+	keepaliveEnabled := cc.keepaliveEnabled
+	historyMaxAge := cc.historyRetention.MaxAge
 	cc.mu.Unlock()
 
-	// The onStatusChange for "Connected" should be called by platformConnect or here.
-	// For WASM, onopen callback handles the "connected" state.
-	// For TCP, platformConnect sets it up.
-	if cc.isTCP {
-		cc.onStatusChange(fmt.Sprintf("Connected to %s:%d (TCP).", ip, port))
-	} // For WS, onopen callback will confirm.
-
+	// "Connected" is reported by platformConnect itself now that native
+	// connections can be TCP or WebSocket (see connect_native.go); for
+	// WASM, the onopen callback confirms it once the handshake actually
+	// completes.
 	cc.wg.Add(1)
-	if cc.isTCP {
-		go cc.processIncomingMessagesNative()
-	} else {
-		go cc.processIncomingMessagesWasm()
+	go cc.startReadLoop()
+
+	if keepaliveEnabled {
+		cc.wg.Add(1)
+		go cc.startKeepalive(shutdownSignal)
+	}
+
+	if historyMaxAge > 0 {
+		cc.wg.Add(1)
+		go cc.startHistoryPurger(shutdownSignal)
 	}
 	return nil
 }
@@ -145,10 +618,32 @@ func (cc *ClientCore) processIncomingMessages() {
 		// Avoid calling Disconnect directly if it was initiated by Disconnect itself.
 		cc.mu.Lock()
 		wasConnected := cc.isConnected
+		shuttingDown := false
+		select {
+		case <-cc.shutdownSignal:
+			shuttingDown = true
+		default:
+		}
 		cc.mu.Unlock()
 		if wasConnected { // Only if we thought we were connected
 			cc.onStatusChange("Connection lost. Attempting to clean up.")
-			cc.Disconnect() // This will handle cleanup
+			// Disconnect() calls cc.wg.Wait(), which would deadlock if run
+			// synchronously here: this goroutine's own wg.Done() (the defer
+			// above) hasn't fired yet, since defers run LIFO and this one
+			// was registered first. Run it in a goroutine, same as every
+			// other Disconnect call reachable from inside the read loop
+			// (see the SERVER_FULL/AUTH_FAILED handling below).
+			go func() {
+				cc.Disconnect()
+				if !shuttingDown {
+					// An unexpected drop, not a caller-requested shutdown -
+					// let scheduleReconnect take over (a no-op unless
+					// SetReconnectPolicy was called), same as
+					// connect_wasm.go's onclose handler does for WASM
+					// builds.
+					cc.scheduleReconnect()
+				}
+			}()
 		}
 	}()
 
@@ -160,16 +655,15 @@ func (cc *ClientCore) processIncomingMessages() {
 		default:
 			// Non-blocking check for connection status before read
 			cc.mu.Lock()
-			if !cc.isConnected || cc.conn == nil || cc.reader == nil {
+			transport := cc.transport
+			if !cc.isConnected || transport == nil {
 				cc.mu.Unlock()
 				// Connection might have been closed by Disconnect()
 				return
 			}
-			// Optionally set a read deadline
-			// cc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
 			cc.mu.Unlock()
 
-			line, err := cc.reader.ReadString('\n')
+			line, err := transport.ReadLine()
 
 			// cc.mu.Lock()
 			// cc.conn.SetReadDeadline(time.Time{}) // Clear deadline
@@ -202,11 +696,11 @@ func (cc *ClientCore) processIncomingMessages() {
 						// However, ReadString blocks, so a timeout here is unusual unless deadline set.
 						// cc.onStatusChange("Read timeout.")
 						// continue; // Or handle as appropriate
-						cc.onError(err, "processIncomingMessages - ReadString timeout")
+						cc.reportError(err, "processIncomingMessages - ReadString timeout")
 					} else {
 						errMsg := fmt.Sprintf("Network error: %v", err)
 						cc.onStatusChange(errMsg)
-						cc.onError(err, "processIncomingMessages - ReadString")
+						cc.reportError(err, "processIncomingMessages - ReadString")
 					}
 				}
 				// Error or EOF, stop processing for this connection
@@ -221,25 +715,231 @@ func (cc *ClientCore) processIncomingMessages() {
 	}
 }
 
+// handleCapNegotiationLine processes one line of the optional CAP
+// LS/REQ/ACK/END handshake (see server.go's negotiateCapabilities for the
+// server side this mirrors). It returns true if trimmedLine was part of
+// that handshake and has been fully handled; false means the server
+// never started capability negotiation (a legacy peer), so the caller
+// should mark negotiation done and fall through to regular login-phase
+// handling of trimmedLine itself.
+func (cc *ClientCore) handleCapNegotiationLine(trimmedLine string) bool {
+	switch {
+	case strings.HasPrefix(trimmedLine, "CAP LS "):
+		offered := strings.Fields(strings.TrimPrefix(trimmedLine, "CAP LS "))
+		var requested []string
+		for _, offer := range offered {
+			for _, supported := range clientSupportedCaps {
+				if offer == supported {
+					requested = append(requested, offer)
+					break
+				}
+			}
+		}
+		if len(requested) == 0 {
+			// Nothing in common with the server; skip straight to CAP
+			// END so the server moves on to REQ_USERNAME.
+			cc.mu.Lock()
+			cc.capNegotiationDone = true
+			cc.mu.Unlock()
+			if err := cc.sendToServer("CAP END"); err != nil {
+				cc.reportError(err, "handleServerMessage - CAP END")
+			}
+			return true
+		}
+		if err := cc.sendToServer("CAP REQ :%s", strings.Join(requested, " ")); err != nil {
+			cc.reportError(err, "handleServerMessage - CAP REQ")
+		}
+		return true
+
+	case strings.HasPrefix(trimmedLine, "CAP ACK"):
+		acked := strings.Fields(strings.TrimPrefix(strings.TrimPrefix(trimmedLine, "CAP ACK"), " :"))
+		caps := make(map[string]bool, len(acked))
+		for _, c := range acked {
+			caps[c] = true
+		}
+		cc.mu.Lock()
+		cc.caps = caps
+		cc.capNegotiationDone = true
+		cc.mu.Unlock()
+		if err := cc.sendToServer("CAP END"); err != nil {
+			cc.reportError(err, "handleServerMessage - CAP END")
+		}
+		return true
+
+	default:
+		return false
+	}
+}
+
 // handleServerMessage processes a single message line from the server.
 func (cc *ClientCore) handleServerMessage(rawLine string) {
 	// rawLine includes the newline. For comparisons, trim it.
 	trimmedLine := strings.TrimSpace(rawLine)
 
+	if strings.HasPrefix(trimmedLine, "RESUME_TOKEN ") {
+		// Issued after every successful login or resume - stored for the
+		// next unexpected disconnect to hand back via RESUME. Tokens are
+		// single-use and rotated on each resume, so the server always
+		// sends a fresh one here.
+		cc.mu.Lock()
+		cc.resumeToken = strings.TrimSpace(strings.TrimPrefix(trimmedLine, "RESUME_TOKEN "))
+		cc.mu.Unlock()
+		return
+	}
+
+	if strings.HasPrefix(trimmedLine, "BATCH +") {
+		// "BATCH +id chathistory <target>" opens a CHATHISTORY response
+		// (see server.go's handleChatHistoryCommand); any other BATCH type
+		// isn't one this client interprets, so it's left unopened and its
+		// lines fall through to onMessageReceived like anything else.
+		fields := strings.Fields(trimmedLine)
+		if len(fields) >= 3 && fields[2] == "chathistory" {
+			// The server echoes RequestHistory's "-" for the global
+			// buffer; it maps back to "" here.
+			target := ""
+			if len(fields) >= 4 && fields[3] != "-" {
+				target = fields[3]
+			}
+			cc.mu.Lock()
+			cc.historyBatchID = strings.TrimPrefix(fields[1], "+")
+			cc.historyBatchTarget = target
+			cc.historyBatchMsgs = nil
+			cc.mu.Unlock()
+			return
+		}
+	}
+
+	if strings.HasPrefix(trimmedLine, "BATCH -") {
+		batchID := strings.TrimPrefix(trimmedLine, "BATCH -")
+		cc.mu.Lock()
+		if cc.historyBatchID != "" && cc.historyBatchID == batchID {
+			target := cc.historyBatchTarget
+			msgs := cc.historyBatchMsgs
+			cc.historyBatchID = ""
+			cc.historyBatchTarget = ""
+			cc.historyBatchMsgs = nil
+			onHistoryBatch := cc.onHistoryBatch
+			cc.mu.Unlock()
+			onHistoryBatch(target, msgs)
+			return
+		}
+		cc.mu.Unlock()
+	}
+
+	cc.mu.Lock()
+	inHistoryBatch := cc.historyBatchID != ""
+	cc.mu.Unlock()
+	if inHistoryBatch && strings.HasPrefix(trimmedLine, "@") {
+		// A line inside an open CHATHISTORY batch; decode it as a framed
+		// protocol.Message rather than handing it to onMessageReceived
+		// raw, same shape formatChatHistoryLine produced it in.
+		if msg, err := protocol.Parse([]byte(trimmedLine)); err == nil {
+			hm := HistoricalMessage{From: msg.Source, Verb: msg.Verb, Text: msg.Trailing}
+			if len(msg.Params) > 0 && msg.Params[0] != "-" {
+				hm.Target = msg.Params[0]
+			}
+			if idStr, ok := msg.Tags["msgid"]; ok {
+				if id, err := strconv.ParseUint(idStr, 10, 64); err == nil {
+					hm.ID = id
+				}
+			}
+			if tsStr, ok := msg.Tags["time"]; ok {
+				if ts, err := time.Parse(time.RFC3339, tsStr); err == nil {
+					hm.Timestamp = ts
+				}
+			}
+			cc.mu.Lock()
+			cc.historyBatchMsgs = append(cc.historyBatchMsgs, hm)
+			cc.mu.Unlock()
+		}
+		return
+	}
+
+	if trimmedLine == "PONG" {
+		// Answers a keepalive PING sent by the ticker in
+		// startKeepalive - resets the missed-pong
+		// deadline and reports the round-trip time, but otherwise isn't
+		// passed to onMessageReceived since it's not a chat line.
+		cc.handleKeepalivePong()
+		return
+	}
+
+	if strings.HasPrefix(trimmedLine, "FAIL RATELIMIT ") {
+		// "FAIL RATELIMIT <verb> <retry_ms>" - the server's verbLimit
+		// rejected something the client's own verbLimiter should already
+		// have paced (see sendRateLimited). Surface it the same way as a
+		// local queue delay rather than a generic error, so a caller like
+		// the CLI can show one consistent "slow down" hint either way.
+		fields := strings.Fields(strings.TrimPrefix(trimmedLine, "FAIL RATELIMIT "))
+		var retry time.Duration
+		if len(fields) >= 2 {
+			if ms, err := strconv.Atoi(fields[1]); err == nil {
+				retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+		cc.onMessageReceived(rawLine)
+		cc.onFlowControl(0, retry)
+		return
+	}
+
 	cc.mu.Lock()
 	inLoginPhase := !cc.loginPhaseComplete
+	capDone := cc.capNegotiationDone
 	cc.mu.Unlock()
 
+	if inLoginPhase && !capDone {
+		if cc.handleCapNegotiationLine(trimmedLine) {
+			return
+		}
+		// Whatever arrived wasn't part of the CAP handshake, so the
+		// server never started one (legacy peer, no capabilities). Fall
+		// through and let the switch below handle trimmedLine as usual.
+		cc.mu.Lock()
+		cc.capNegotiationDone = true
+		cc.mu.Unlock()
+	}
+
 	if inLoginPhase {
 		switch trimmedLine {
 		case "REQ_USERNAME":
-			cc.onUsernameRequested()
+			cc.mu.Lock()
+			token := cc.resumeToken
+			cached := cc.username
+			cc.mu.Unlock()
+			lastSeen := atomic.LoadUint64(&cc.lastSeenSeq)
+			if token != "" {
+				if err := cc.sendToServer("RESUME %s %d", token, lastSeen); err != nil {
+					cc.reportError(err, "handleServerMessage - RESUME")
+				}
+			} else if cached != "" {
+				// A reconnect after a prior successful login (no resume
+				// token available, e.g. the server doesn't support RESUME or
+				// EnableResume was never called) - replay the username we
+				// already know instead of prompting the user again.
+				if err := cc.SendUsername(cached); err != nil {
+					cc.reportError(err, "handleServerMessage - replay username")
+				}
+			} else {
+				cc.onUsernameRequested()
+			}
 		case "SERVER_FULL":
 			cc.onMessageReceived(rawLine) // Pass full message
 			cc.onStatusChange("Server is full. Disconnecting.")
 			go cc.Disconnect() // Disconnect in a goroutine to avoid deadlock if called from read loop
+		case "AUTH+":
+			// Server is ready for the PLAIN SASL response; ask the UI for
+			// the password now, rather than up front, so nothing is held
+			// in memory before the server has actually asked for it.
+			cc.onPasswordRequested()
 		default:
-			if strings.HasPrefix(trimmedLine, "Welcome, ") {
+			if strings.HasPrefix(trimmedLine, "AUTH ") {
+				// Server advertises the mechanisms it supports for this
+				// user (e.g. "AUTH PLAIN SCRAM-SHA-256"). The native/WASM
+				// clients only implement PLAIN so far.
+				if err := cc.sendToServer("AUTH %s", "PLAIN"); err != nil {
+					cc.reportError(err, "handleServerMessage - AUTH select")
+				}
+			} else if strings.HasPrefix(trimmedLine, "Welcome, ") {
 				// Extract username from "Welcome, <username>!"
 				// Example: "Welcome, alice!" -> "alice"
 				var welcomeUsername string
@@ -256,17 +956,56 @@ func (cc *ClientCore) handleServerMessage(rawLine string) {
 				cc.mu.Lock()
 				cc.loginPhaseComplete = true
 				cc.username = welcomeUsername // Store confirmed username
+				cc.reconnectAttempt = 0
 				cc.mu.Unlock()
+				atomic.StoreUint64(&cc.lastSeenSeq, 0)
 
 				cc.onMessageReceived(rawLine) // Pass full welcome message
+				cc.replayHistory()
 				if welcomeUsername != "" {
 					cc.onLoginSuccess(welcomeUsername) // Invoke new callback
 				} else {
 					// Fallback or error if username couldn't be parsed, though server should ensure format
 					cc.onLoginSuccess("user") // Or handle error
 				}
+				cc.resumeOutboundDrain()
+			} else if strings.HasPrefix(trimmedLine, "RESUME_OK ") {
+				// Resume succeeded; this plays the role "Welcome, " plays
+				// for a fresh login, but fires OnResumeFunc instead of
+				// OnLoginSuccessFunc so the caller can skip re-prompting.
+				resumedUsername := strings.TrimSpace(strings.TrimPrefix(trimmedLine, "RESUME_OK "))
+
+				cc.mu.Lock()
+				cc.loginPhaseComplete = true
+				cc.username = resumedUsername
+				cc.resumeToken = "" // consumed server-side; a fresh one follows via RESUME_TOKEN
+				cc.reconnectAttempt = 0
+				cc.mu.Unlock()
+				atomic.StoreUint64(&cc.lastSeenSeq, 0)
+
+				cc.onMessageReceived(rawLine)
+				cc.replayHistory()
+				cc.onResume(resumedUsername)
+				cc.resumeOutboundDrain()
+			} else if trimmedLine == "RESUME_FAILED" || strings.HasPrefix(trimmedLine, "RESUME_FAILED ") {
+				reason := strings.TrimSpace(strings.TrimPrefix(trimmedLine, "RESUME_FAILED"))
+
+				cc.mu.Lock()
+				cc.resumeToken = ""
+				cc.mu.Unlock()
+
+				cc.onMessageReceived(rawLine)
+				cc.onResumeFailed(reason)
+				// The server closes the connection on a failed resume,
+				// same as AUTH_FAILED/BAD_USERNAME; the read loop's own
+				// EOF handling runs the usual cleanup, and since
+				// resumeToken is now cleared, the next REQ_USERNAME (after
+				// scheduleReconnect or a manual Connect) gets a fresh login
+				// that replays the cached username, or prompts if there is
+				// none, instead of another RESUME.
 			} else if strings.HasPrefix(trimmedLine, "BAD_USERNAME") ||
-				strings.HasPrefix(trimmedLine, "NOT_ALLOWED") {
+				strings.HasPrefix(trimmedLine, "NOT_ALLOWED") ||
+				strings.HasPrefix(trimmedLine, "AUTH_FAILED") {
 				cc.onMessageReceived(rawLine) // Pass full error message
 				cc.onStatusChange("Login failed by server. Disconnecting.")
 				go cc.Disconnect()
@@ -276,10 +1015,25 @@ func (cc *ClientCore) handleServerMessage(rawLine string) {
 			}
 		}
 	} else { // Login phase complete, regular messages
+		atomic.AddUint64(&cc.lastSeenSeq, 1)
 		cc.onMessageReceived(rawLine)
+		go cc.persistIncoming(rawLine)
 	}
 }
 
+// reportError logs err via the package's default logger - with context
+// promoted to a structured field rather than folded into the message
+// string - and then invokes the caller's onError callback, same as a
+// direct cc.onError call. Internal error sites go through this instead
+// of onError directly, so the logger sees the errors a consumer does
+// without that consumer having to log them itself; the exceptions are
+// connect_wasm.go's two WebSocket error paths, which call onError
+// without logging.
+func (cc *ClientCore) reportError(err error, context string) {
+	logging.Error(err.Error(), logging.F("context", context))
+	cc.onError(err, context)
+}
+
 // sendToServer is a helper to send a formatted string to the server.
 // It ensures a newline is appended.
 func (cc *ClientCore) sendToServer(format string, args ...interface{}) error {
@@ -296,34 +1050,157 @@ func (cc *ClientCore) sendToServer(format string, args ...interface{}) error {
 	}
 
 	var err error
-	if cc.isTCP {
-		if cc.writer == nil {
-			cc.mu.Unlock()
-			return fmt.Errorf("writer not initialized for TCP")
-		}
-		_, err = cc.writer.WriteString(message)
-		if err == nil {
-			err = cc.writer.Flush()
-		}
-	} else { // WebSocket
-		if !cc.ws.Truthy() {
+	if cc.transport != nil {
+		err = cc.transport.WriteLine(message)
+	} else { // WASM: WebSocket via wsConn (see connect_wasm.go)
+		if cc.ws == nil || !cc.ws.valid() {
 			cc.mu.Unlock()
 			return fmt.Errorf("websocket not initialized")
 		}
-		cc.ws.Call("send", message) // WebSocket send method
+		cc.ws.send(message)
 		// WebSocket send doesn't typically return an error directly like this.
 		// Errors are usually handled via 'onerror' or if the connection closes.
 	}
 	cc.mu.Unlock() // Unlock after send
 
 	if err != nil {
-		cc.onError(err, "sendToServer")
+		cc.reportError(err, "sendToServer")
 		// go cc.Disconnect() // Consider this
 		return fmt.Errorf("failed to send to server: %w", err)
 	}
 	return nil
 }
 
+// sendRateLimited runs send immediately if verbLimiter currently admits
+// verb, mirroring the server's own per-verb token bucket so a burst of
+// sends gets paced client-side instead of arriving only to be rejected
+// with FAIL RATELIMIT. When the bucket is empty, send is queued (bounded
+// by maxQueuedOutbound) and a background drainOutboundQueue call takes
+// over; onFlowControl fires for each queued send (not for one sent at
+// once, nor for one dropped because the queue is full) so a caller like
+// the CLI can show a hint.
+func (cc *ClientCore) sendRateLimited(verb string, send func() error) error {
+	cc.mu.Lock()
+	ok, delay := cc.verbLimiter.Allow(verb)
+	if ok {
+		cc.mu.Unlock()
+		return send()
+	}
+
+	if len(cc.outboundQueue) >= maxQueuedOutbound {
+		cc.mu.Unlock()
+		return fmt.Errorf("rate limited: outbound queue full, %s dropped", verb)
+	}
+	cc.outboundQueue = append(cc.outboundQueue, queuedSend{verb: verb, send: send})
+	pending := len(cc.outboundQueue)
+	alreadyDraining := cc.draining
+	cc.draining = true
+	cc.mu.Unlock()
+
+	cc.onFlowControl(pending, delay)
+	if !alreadyDraining {
+		go cc.drainOutboundQueue(delay)
+	}
+	return nil
+}
+
+// drainOutboundQueue retries the head of outboundQueue, waiting as long
+// as verbLimiter says to between attempts, until the queue is empty. Only
+// one of these runs at a time per ClientCore (see the draining flag in
+// sendRateLimited). If the connection drops mid-drain, it stops (leaving
+// whatever's left in outboundQueue queued rather than dropped) and
+// returns; resumeOutboundDrain restarts it once reconnect succeeds.
+func (cc *ClientCore) drainOutboundQueue(wait time.Duration) {
+	for {
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+
+		cc.mu.Lock()
+		if len(cc.outboundQueue) == 0 {
+			cc.draining = false
+			cc.mu.Unlock()
+			return
+		}
+		if !cc.isConnected {
+			cc.draining = false
+			cc.mu.Unlock()
+			return
+		}
+		next := cc.outboundQueue[0]
+		ok, delay := cc.verbLimiter.Allow(next.verb)
+		if !ok {
+			cc.mu.Unlock()
+			wait = delay
+			continue
+		}
+		cc.outboundQueue = cc.outboundQueue[1:]
+		pending := len(cc.outboundQueue)
+		cc.mu.Unlock()
+
+		if err := next.send(); err != nil {
+			cc.reportError(err, "drainOutboundQueue")
+		}
+		cc.onFlowControl(pending, 0)
+		wait = 0
+	}
+}
+
+// resumeOutboundDrain restarts drainOutboundQueue after a reconnect if
+// messages queued before the drop are still waiting to go out (see the
+// isConnected check added to drainOutboundQueue); a no-op if the queue
+// is empty or something's already draining it.
+func (cc *ClientCore) resumeOutboundDrain() {
+	cc.mu.Lock()
+	if cc.draining || len(cc.outboundQueue) == 0 {
+		cc.mu.Unlock()
+		return
+	}
+	cc.draining = true
+	cc.mu.Unlock()
+	go cc.drainOutboundQueue(0)
+}
+
+// nextMessageID returns a small, monotonically increasing tag value used
+// to correlate an outbound message with a future ack or echo from the
+// server, e.g. "c1", "c2". It's only attached to a message once
+// "message-tags" has been negotiated.
+func (cc *ClientCore) nextMessageID() string {
+	id := atomic.AddUint64(&cc.nextMsgID, 1)
+	return "c" + strconv.FormatUint(id, 10)
+}
+
+// sendTaggedToServer sends verb/params/trailing as a single message. If
+// "message-tags" was negotiated with the server, it's framed via
+// protocol.Message with a msgid tag; otherwise it falls back to the
+// plain "verb param... trailing" legacy line the server has always
+// understood, so callers don't need their own capability check and a
+// server that never ACKed message-tags keeps working exactly as before.
+func (cc *ClientCore) sendTaggedToServer(verb string, params []string, trailing string) error {
+	cc.mu.Lock()
+	tagged := cc.caps["message-tags"]
+	cc.mu.Unlock()
+
+	if !tagged {
+		parts := append(append([]string{verb}, params...), trailing)
+		return cc.sendToServer("%s", strings.Join(parts, " "))
+	}
+
+	msg := protocol.Message{
+		Tags:        map[string]string{"msgid": cc.nextMessageID()},
+		Verb:        verb,
+		Params:      params,
+		Trailing:    trailing,
+		HasTrailing: true,
+	}
+	var encoded strings.Builder
+	if err := msg.Encode(&encoded); err != nil {
+		cc.reportError(err, "sendTaggedToServer - Encode")
+		return err
+	}
+	return cc.sendToServer("%s", strings.TrimRight(encoded.String(), "\r\n"))
+}
+
 // SendUsername sends the chosen username to the server.
 func (cc *ClientCore) SendUsername(username string) error {
 	cc.mu.Lock()
@@ -349,9 +1226,35 @@ func (cc *ClientCore) SendUsername(username string) error {
 		// return fmt.Errorf("username too long")
 	}
 
+	cc.mu.Lock()
+	cc.pendingUsername = username // remembered for the AUTH exchange until Welcome confirms it
+	cc.mu.Unlock()
+
 	return cc.sendToServer("%s", username)
 }
 
+// SendPassword responds to the server's AUTH+ prompt with the SASL PLAIN
+// blob ("\x00username\x00password", base64-encoded). Call it only after
+// onPasswordRequested fires.
+func (cc *ClientCore) SendPassword(password string) error {
+	cc.mu.Lock()
+	if !cc.isConnected {
+		cc.mu.Unlock()
+		cc.onStatusChange("Cannot send password: Not connected.")
+		return fmt.Errorf("not connected")
+	}
+	if cc.loginPhaseComplete {
+		cc.mu.Unlock()
+		cc.onStatusChange("Cannot send password: Login already complete.")
+		return fmt.Errorf("login already complete")
+	}
+	username := cc.pendingUsername
+	cc.mu.Unlock()
+
+	blob := "\x00" + username + "\x00" + password
+	return cc.sendToServer("%s", base64.StdEncoding.EncodeToString([]byte(blob)))
+}
+
 // SendGlobalMessage sends a global chat message to the server.
 func (cc *ClientCore) SendGlobalMessage(message string) error {
 	cc.mu.Lock()
@@ -365,7 +1268,9 @@ func (cc *ClientCore) SendGlobalMessage(message string) error {
 	if message == "" {
 		return nil // Don't send empty messages
 	}
-	return cc.sendToServer("%s", message) // Server expects just the message
+	return cc.sendRateLimited("GLOBAL", func() error {
+		return cc.sendToServer("%s", message) // Server expects just the message
+	})
 }
 
 // SendDirectMessage sends a direct message to a recipient via the server.
@@ -384,7 +1289,9 @@ func (cc *ClientCore) SendDirectMessage(recipient, message string) error {
 	if len(recipient) >= CoreUsernameMaxLen {
 		return fmt.Errorf("recipient username too long")
 	}
-	return cc.sendToServer("PRIVMSG %s %s", recipient, message)
+	return cc.sendRateLimited("PRIVMSG", func() error {
+		return cc.sendTaggedToServer("PRIVMSG", []string{recipient}, message)
+	})
 }
 
 // SendGroupMessage sends a message to a group via the server.
@@ -403,7 +1310,36 @@ func (cc *ClientCore) SendGroupMessage(groupname, message string) error {
 	if len(groupname) >= CoreGroupNameMaxLen {
 		return fmt.Errorf("groupname too long")
 	}
-	return cc.sendToServer("GROUPMSG %s %s", groupname, message)
+	return cc.sendRateLimited("GROUPMSG", func() error {
+		return cc.sendTaggedToServer("GROUPMSG", []string{groupname}, message)
+	})
+}
+
+// RequestHistory asks the server for up to n historical messages for
+// target ("" for the global buffer, a group name, or a DM peer's
+// username), sending CHATHISTORY LATEST when before is "" or
+// CHATHISTORY BEFORE <before> otherwise. The result arrives later via
+// onHistoryBatch (see SetOnHistoryBatch) once the server's BATCH closes.
+func (cc *ClientCore) RequestHistory(target, before string, n int) error {
+	cc.mu.Lock()
+	if !cc.isConnected || !cc.loginPhaseComplete {
+		cc.mu.Unlock()
+		cc.onStatusChange("Cannot request history: Not connected or not logged in.")
+		return fmt.Errorf("not connected or not logged in")
+	}
+	cc.mu.Unlock()
+
+	wireTarget := target
+	if wireTarget == "" {
+		wireTarget = "-"
+	}
+
+	return cc.sendRateLimited("CHATHISTORY", func() error {
+		if before == "" {
+			return cc.sendToServer("CHATHISTORY LATEST %s %d", wireTarget, n)
+		}
+		return cc.sendToServer("CHATHISTORY BEFORE %s %s %d", wireTarget, before, n)
+	})
 }
 
 // Disconnect closes the connection to the server and cleans up resources.
@@ -420,14 +1356,11 @@ func (cc *ClientCore) Disconnect() {
 		close(cc.shutdownSignal)
 	}
 
-	if cc.isTCP && cc.conn != nil {
-		cc.conn.Close()
-	} else if !cc.isTCP && cc.ws.Truthy() {
-		// Check WebSocket state before closing: 0=CONNECTING, 1=OPEN, 2=CLOSING, 3=CLOSED
-		readyState := cc.ws.Get("readyState").Int()
-		if readyState == 0 || readyState == 1 { // CONNECTING or OPEN
-			cc.ws.Call("close")
-		}
+	if cc.transport != nil {
+		cc.transport.Close()
+		cc.transport = nil
+	} else if cc.ws != nil && cc.ws.valid() {
+		cc.ws.close() // only closes if still CONNECTING/OPEN; see jsWSConn.close
 	}
 	cc.isConnected = false
 	// ... (reset other fields)
@@ -0,0 +1,79 @@
+// tincan/internal/client/core/core_test.go
+package core
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// fakeTransport is a minimal Transport whose ReadLine blocks until
+// triggerReadError is called, letting a test drive processIncomingMessages'
+// error path on demand without a real socket.
+type fakeTransport struct {
+	errCh chan error
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{errCh: make(chan error, 1)}
+}
+
+func (f *fakeTransport) Dial(string) error         { return nil }
+func (f *fakeTransport) ReadLine() (string, error) { return "", <-f.errCh }
+func (f *fakeTransport) WriteLine(string) error    { return nil }
+func (f *fakeTransport) Close() error              { return nil }
+
+func (f *fakeTransport) triggerReadError(err error) { f.errCh <- err }
+
+// TestProcessIncomingMessagesReconnectsWithoutDeadlock simulates an
+// unexpected read error on the native transport and checks that the
+// read-loop goroutine actually returns instead of hanging forever.
+// processIncomingMessages' cleanup defer used to call cc.Disconnect()
+// synchronously, which calls cc.wg.Wait() - but that's the same
+// goroutine wg is waiting on (its own defer cc.wg.Done() hadn't run yet,
+// since defers are LIFO), so every unexpected disconnect deadlocked the
+// read loop permanently. It's also the hook point scheduleReconnect must
+// be wired into on native builds, same as connect_wasm.go's onclose
+// handler already does for WASM.
+func TestProcessIncomingMessagesReconnectsWithoutDeadlock(t *testing.T) {
+	cc := NewClientCore(nil, nil, nil, nil, nil, nil)
+	// A short-lived, bounded reconnect policy: enough to exercise
+	// scheduleReconnect without the test hanging on real backoff, and
+	// port 1 on loopback has nothing listening so the redial fails fast.
+	cc.SetReconnectPolicy(time.Millisecond, time.Millisecond, 1, 0)
+
+	ft := newFakeTransport()
+	cc.mu.Lock()
+	cc.transport = ft
+	cc.isConnected = true
+	cc.serverIP = "127.0.0.1"
+	cc.serverPort = 1
+	cc.mu.Unlock()
+
+	cc.wg.Add(1)
+	done := make(chan struct{})
+	go func() {
+		cc.processIncomingMessages()
+		close(done)
+	}()
+
+	ft.triggerReadError(io.EOF)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("processIncomingMessages did not return - looks like it deadlocked in Disconnect/wg.Wait")
+	}
+
+	// Disconnect and scheduleReconnect now run in a background goroutine
+	// (see the fix above), so give them a moment to settle rather than
+	// asserting on them immediately.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if !cc.IsConnected() && !cc.IsReconnecting() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("client never settled after the unexpected disconnect - still connected or stuck reconnecting")
+}
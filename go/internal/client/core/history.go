@@ -0,0 +1,182 @@
+// tincan/internal/client/core/history.go
+package core
+
+import (
+	"strings"
+	"time"
+
+	"tincan/internal/client/protocol"
+)
+
+// defaultHistoryReplayCount bounds replayHistory's Load call when
+// HistoryRetention.MaxMessages is unset (zero), so a store that's
+// accumulated a long-running cache doesn't dump everything it has back
+// through onMessageReceived on every login.
+const defaultHistoryReplayCount = 50
+
+// historyPurgeInterval is how often startHistoryPurger calls Purge once
+// HistoryRetention.MaxAge is set - a purge is maintenance, not something
+// that needs to run more often than this.
+const historyPurgeInterval = 10 * time.Minute
+
+// Message is the persisted form of one chat line, written by a
+// HistoryStore and replayed through onMessageReceived on login (see
+// replayHistory) before a session switches over to live messages.
+// Channel mirrors HistoricalMessage.Target: "" for the global buffer, a
+// username for a DM, a group name for a GM.
+type Message struct {
+	Channel   string
+	From      string
+	Text      string
+	Timestamp time.Time
+}
+
+// HistoryStore persists chat messages across restarts/logins. Append and
+// Purge are called from background goroutines (see persistIncoming,
+// startHistoryPurger) so a slow disk/IndexedDB write never blocks the
+// read loop; Load is only called synchronously, right after login, by
+// replayHistory. See NewBoltHistoryStore (native) and
+// NewIndexedDBHistoryStore (WASM) for the two implementations
+// SetHistoryStore expects.
+type HistoryStore interface {
+	Append(msg Message) error
+	Load(channel string, limit int) ([]Message, error)
+	Purge(before time.Time) error
+}
+
+// HistoryRetention bounds what a HistoryStore keeps. MaxMessages is
+// enforced per-channel by the store itself on every Append (see
+// boltHistoryStore/indexedDBHistoryStore) and doubles as replayHistory's
+// default replay count; MaxAge is enforced by startHistoryPurger calling
+// Purge on a timer. Either left at zero disables that half of retention.
+type HistoryRetention struct {
+	MaxMessages int
+	MaxAge      time.Duration
+}
+
+// noopHistoryStore is the default HistoryStore: persistence is opt-in,
+// matching EnableResume/SetKeepalive's pattern of doing nothing until a
+// caller explicitly configures it.
+type noopHistoryStore struct{}
+
+func (noopHistoryStore) Append(Message) error                { return nil }
+func (noopHistoryStore) Load(string, int) ([]Message, error) { return nil, nil }
+func (noopHistoryStore) Purge(time.Time) error               { return nil }
+
+// SetHistoryStore enables persistent message history (see HistoryStore).
+// Call before Connect so the store is in place before replayHistory and
+// persistIncoming need it; passing nil restores the no-op default.
+func (cc *ClientCore) SetHistoryStore(store HistoryStore) {
+	if store == nil {
+		store = noopHistoryStore{}
+	}
+	cc.mu.Lock()
+	cc.historyStore = store
+	cc.mu.Unlock()
+}
+
+// SetHistoryRetention configures the bounds startHistoryPurger and
+// replayHistory use (see HistoryRetention). Call before Connect.
+func (cc *ClientCore) SetHistoryRetention(r HistoryRetention) {
+	cc.mu.Lock()
+	cc.historyRetention = r
+	cc.mu.Unlock()
+}
+
+// replayHistory loads and replays the global channel's recent history
+// through onMessageReceived right after login, before any live message
+// can arrive (handleServerMessage runs this synchronously on the same
+// read-loop goroutine that will process whatever comes next). Only the
+// global channel is replayed: Load takes a channel name, but nothing in
+// ClientCore tracks which DM/GM channels a user has ever spoken in, so
+// there's no list to iterate a true per-channel replay over yet.
+func (cc *ClientCore) replayHistory() {
+	cc.mu.Lock()
+	store := cc.historyStore
+	limit := cc.historyRetention.MaxMessages
+	cc.mu.Unlock()
+	if limit <= 0 {
+		limit = defaultHistoryReplayCount
+	}
+
+	msgs, err := store.Load("", limit)
+	if err != nil {
+		cc.reportError(err, "replayHistory - Load")
+		return
+	}
+	for _, msg := range msgs {
+		from := msg.From
+		if from == "" {
+			from = "history"
+		}
+		cc.onMessageReceived(from + ": " + msg.Text + "\n")
+	}
+}
+
+// persistIncoming decodes rawLine the same way handleServerMessage's
+// CHATHISTORY batch decoder does and writes the result to historyStore
+// asynchronously. Only a framed line (message-tags negotiated - see
+// clientSupportedCaps) carries enough structure to attribute a
+// channel/sender; an unframed legacy line is still stored, under the
+// global channel, with its raw text as-is, rather than dropped, since the
+// cache should reflect what the user actually saw either way.
+func (cc *ClientCore) persistIncoming(rawLine string) {
+	trimmed := strings.TrimSpace(rawLine)
+	if trimmed == "" {
+		return
+	}
+
+	msg := Message{Text: trimmed, Timestamp: time.Now()}
+	if parsed, err := protocol.Parse([]byte(trimmed)); err == nil {
+		msg.From = parsed.Source
+		if parsed.HasTrailing {
+			msg.Text = parsed.Trailing
+		}
+		if tsStr, ok := parsed.Tags["time"]; ok {
+			if ts, err := time.Parse(time.RFC3339, tsStr); err == nil {
+				msg.Timestamp = ts
+			}
+		}
+		switch parsed.Verb {
+		case "PRIVMSG", "GROUPMSG":
+			if len(parsed.Params) > 0 {
+				msg.Channel = parsed.Params[0]
+			}
+		}
+	}
+
+	cc.mu.Lock()
+	store := cc.historyStore
+	cc.mu.Unlock()
+	if err := store.Append(msg); err != nil {
+		cc.reportError(err, "persistIncoming - Append")
+	}
+}
+
+// startHistoryPurger runs until shutdownSignal closes, calling
+// Purge(now - MaxAge) every historyPurgeInterval. It returns immediately
+// (without looping) if MaxAge is unset, the same opt-in-by-default
+// pattern as startKeepalive.
+func (cc *ClientCore) startHistoryPurger(shutdownSignal chan struct{}) {
+	defer cc.wg.Done()
+	cc.mu.Lock()
+	store := cc.historyStore
+	maxAge := cc.historyRetention.MaxAge
+	cc.mu.Unlock()
+	if maxAge <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(historyPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdownSignal:
+			return
+		case <-ticker.C:
+			if err := store.Purge(time.Now().Add(-maxAge)); err != nil {
+				cc.reportError(err, "startHistoryPurger - Purge")
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+//go:build !js || !wasm
+
+// tincan/internal/client/core/history_native.go
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// boltHistoryStore is the native HistoryStore, backed by a single BoltDB
+// file with one bucket per channel (bucketNameFor maps the global "" channel
+// to a real bucket name, since bbolt buckets can't be empty-named), each
+// message JSON-encoded under a key that sorts chronologically. This
+// snapshot has no database/sql driver vendored, so JSON-in-bbolt is the
+// simplest format that doesn't need one.
+type boltHistoryStore struct {
+	db        *bbolt.DB
+	retention HistoryRetention
+}
+
+// globalBucketName is bucketNameFor("")'s target: bbolt rejects an empty
+// bucket name, so the global channel needs a real one.
+const globalBucketName = "_global"
+
+// NewBoltHistoryStore opens (creating if necessary) a BoltDB file at path
+// for use as a ClientCore's HistoryStore (see SetHistoryStore). retention
+// bounds how many messages Append keeps per channel; a zero
+// MaxMessages disables that trim.
+func NewBoltHistoryStore(path string, retention HistoryRetention) (HistoryStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("history: opening %s: %w", path, err)
+	}
+	return &boltHistoryStore{db: db, retention: retention}, nil
+}
+
+func bucketNameFor(channel string) []byte {
+	if channel == "" {
+		return []byte(globalBucketName)
+	}
+	return []byte(channel)
+}
+
+func (s *boltHistoryStore) Append(msg Message) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketNameFor(msg.Channel))
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		key := []byte(fmt.Sprintf("%020d", msg.Timestamp.UnixNano()))
+		if err := bucket.Put(key, value); err != nil {
+			return err
+		}
+		if s.retention.MaxMessages > 0 {
+			trimOldest(bucket, s.retention.MaxMessages)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("history: append: %w", err)
+	}
+	return nil
+}
+
+// trimOldest deletes keys from the front of bucket (bbolt iterates keys
+// in sorted order, and keys are UnixNano timestamps, so "front" is
+// "oldest") until at most max remain. Called with an Update transaction
+// already open by Append.
+func trimOldest(bucket *bbolt.Bucket, max int) {
+	remaining := bucket.Stats().KeyN
+	c := bucket.Cursor()
+	for k, _ := c.First(); k != nil && remaining > max; k, _ = c.Next() {
+		c.Delete()
+		remaining--
+	}
+}
+
+func (s *boltHistoryStore) Load(channel string, limit int) ([]Message, error) {
+	var msgs []Message
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketNameFor(channel))
+		if bucket == nil {
+			return nil
+		}
+		var all []Message
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var msg Message
+			if err := json.Unmarshal(v, &msg); err != nil {
+				continue // Skip a corrupt/foreign-format entry rather than failing the whole load.
+			}
+			all = append(all, msg)
+		}
+		if limit > 0 && len(all) > limit {
+			all = all[len(all)-limit:]
+		}
+		msgs = all
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("history: load: %w", err)
+	}
+	return msgs, nil
+}
+
+func (s *boltHistoryStore) Purge(before time.Time) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			c := bucket.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var msg Message
+				if err := json.Unmarshal(v, &msg); err != nil {
+					continue
+				}
+				if msg.Timestamp.Before(before) {
+					if err := c.Delete(); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("history: purge: %w", err)
+	}
+	return nil
+}
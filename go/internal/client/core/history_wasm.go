@@ -0,0 +1,275 @@
+//go:build js && wasm
+
+// tincan/internal/client/core/history_wasm.go
+package core
+
+import (
+	"fmt"
+	"syscall/js"
+	"time"
+)
+
+// indexedDBStoreName and indexedDBVersion name and version the single
+// object store indexedDBHistoryStore keeps all channels' messages in,
+// indexed by "channel" so Load/Purge can range over just one channel (or
+// all of them) without a full table scan.
+const indexedDBStoreName = "messages"
+const indexedDBVersion = 1
+
+// indexedDBHistoryStore is the WASM HistoryStore, backed by
+// window.indexedDB via syscall/js. Every indexedDB call is async
+// (onsuccess/onerror fire later on the JS event loop), so each method
+// here blocks its calling goroutine on a channel until the matching
+// callback fires, rather than returning a JS promise the rest of
+// ClientCore would have to thread through its otherwise synchronous Go
+// API - Append/Purge are already called from a goroutine for exactly
+// this reason (see persistIncoming/startHistoryPurger).
+type indexedDBHistoryStore struct {
+	dbName    string
+	retention HistoryRetention
+}
+
+// NewIndexedDBHistoryStore returns a HistoryStore backed by dbName (see
+// SetHistoryStore). Opening the database - and creating indexedDBStoreName
+// on first use - happens lazily on the first Append/Load/Purge call.
+func NewIndexedDBHistoryStore(dbName string, retention HistoryRetention) HistoryStore {
+	return &indexedDBHistoryStore{dbName: dbName, retention: retention}
+}
+
+// openDB opens s.dbName, creating indexedDBStoreName (keyed by an
+// auto-incrementing id, with a non-unique index on "channel") the first
+// time a browser profile has seen this database.
+func (s *indexedDBHistoryStore) openDB() (js.Value, error) {
+	result := make(chan js.Value, 1)
+	errCh := make(chan error, 1)
+
+	req := js.Global().Get("indexedDB").Call("open", s.dbName, indexedDBVersion)
+
+	var onupgrade, onsuccess, onerror js.Func
+	onupgrade = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		db := req.Get("result")
+		names := db.Get("objectStoreNames")
+		if !names.Call("contains", indexedDBStoreName).Bool() {
+			store := db.Call("createObjectStore", indexedDBStoreName, map[string]interface{}{
+				"keyPath":       "id",
+				"autoIncrement": true,
+			})
+			store.Call("createIndex", "channel", "channel", map[string]interface{}{"unique": false})
+		}
+		return nil
+	})
+	onsuccess = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		onupgrade.Release()
+		onsuccess.Release()
+		onerror.Release()
+		result <- req.Get("result")
+		return nil
+	})
+	onerror = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		onupgrade.Release()
+		onsuccess.Release()
+		onerror.Release()
+		errCh <- fmt.Errorf("history: indexedDB.open(%s): %s", s.dbName, req.Get("error").Call("toString").String())
+		return nil
+	})
+	req.Set("onupgradeneeded", onupgrade)
+	req.Set("onsuccess", onsuccess)
+	req.Set("onerror", onerror)
+
+	select {
+	case db := <-result:
+		return db, nil
+	case err := <-errCh:
+		return js.Value{}, err
+	}
+}
+
+// messageToJS and messageFromJS convert between Message and the plain JS
+// object stored in/retrieved from indexedDBStoreName; IndexedDB stores
+// structured-cloned JS values, not JSON text, so there's no
+// encoding/json round trip here the way boltHistoryStore needs one.
+func messageToJS(msg Message) map[string]interface{} {
+	return map[string]interface{}{
+		"channel":   msg.Channel,
+		"from":      msg.From,
+		"text":      msg.Text,
+		"timestamp": msg.Timestamp.UnixMilli(),
+	}
+}
+
+func messageFromJS(v js.Value) Message {
+	return Message{
+		Channel:   v.Get("channel").String(),
+		From:      v.Get("from").String(),
+		Text:      v.Get("text").String(),
+		Timestamp: time.UnixMilli(int64(v.Get("timestamp").Float())),
+	}
+}
+
+func (s *indexedDBHistoryStore) Append(msg Message) error {
+	db, err := s.openDB()
+	if err != nil {
+		return err
+	}
+	tx := db.Call("transaction", []interface{}{indexedDBStoreName}, "readwrite")
+	store := tx.Call("objectStore", indexedDBStoreName)
+	req := store.Call("add", messageToJS(msg))
+
+	done := make(chan error, 1)
+	var onsuccess, onerror js.Func
+	onsuccess = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		onsuccess.Release()
+		onerror.Release()
+		done <- nil
+		return nil
+	})
+	onerror = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		onsuccess.Release()
+		onerror.Release()
+		done <- fmt.Errorf("history: indexedDB add: %s", req.Get("error").Call("toString").String())
+		return nil
+	})
+	req.Set("onsuccess", onsuccess)
+	req.Set("onerror", onerror)
+
+	if err := <-done; err != nil {
+		return err
+	}
+	if s.retention.MaxMessages > 0 {
+		s.trimChannel(msg.Channel)
+	}
+	return nil
+}
+
+// trimChannel best-effort deletes channel's entries older than the
+// oldest one that should survive s.retention.MaxMessages, using the
+// "channel" index so other channels aren't touched (unlike Purge, which
+// sweeps every channel by age). Failures are swallowed since trimming is
+// maintenance, not something Append's caller should fail over.
+func (s *indexedDBHistoryStore) trimChannel(channel string) {
+	all, err := s.Load(channel, 0)
+	if err != nil || len(all) <= s.retention.MaxMessages {
+		return
+	}
+	cutoff := all[len(all)-s.retention.MaxMessages].Timestamp
+
+	db, err := s.openDB()
+	if err != nil {
+		return
+	}
+	tx := db.Call("transaction", []interface{}{indexedDBStoreName}, "readwrite")
+	store := tx.Call("objectStore", indexedDBStoreName)
+	index := store.Call("index", "channel")
+	req := index.Call("openCursor", channel)
+
+	done := make(chan struct{}, 1)
+	var onsuccess, onerror js.Func
+	onsuccess = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		cursor := req.Get("result")
+		if cursor.IsNull() {
+			onsuccess.Release()
+			onerror.Release()
+			done <- struct{}{}
+			return nil
+		}
+		if messageFromJS(cursor.Get("value")).Timestamp.Before(cutoff) {
+			cursor.Call("delete")
+		}
+		cursor.Call("continue")
+		return nil
+	})
+	onerror = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		onsuccess.Release()
+		onerror.Release()
+		done <- struct{}{}
+		return nil
+	})
+	req.Set("onsuccess", onsuccess)
+	req.Set("onerror", onerror)
+	<-done
+}
+
+func (s *indexedDBHistoryStore) Load(channel string, limit int) ([]Message, error) {
+	db, err := s.openDB()
+	if err != nil {
+		return nil, err
+	}
+	tx := db.Call("transaction", []interface{}{indexedDBStoreName}, "readonly")
+	store := tx.Call("objectStore", indexedDBStoreName)
+	index := store.Call("index", "channel")
+	req := index.Call("getAll", channel)
+
+	type result struct {
+		msgs []Message
+		err  error
+	}
+	done := make(chan result, 1)
+	var onsuccess, onerror js.Func
+	onsuccess = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		onsuccess.Release()
+		onerror.Release()
+		rows := req.Get("result")
+		n := rows.Length()
+		msgs := make([]Message, n)
+		for i := 0; i < n; i++ {
+			msgs[i] = messageFromJS(rows.Index(i))
+		}
+		done <- result{msgs: msgs}
+		return nil
+	})
+	onerror = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		onsuccess.Release()
+		onerror.Release()
+		done <- result{err: fmt.Errorf("history: indexedDB getAll: %s", req.Get("error").Call("toString").String())}
+		return nil
+	})
+	req.Set("onsuccess", onsuccess)
+	req.Set("onerror", onerror)
+
+	r := <-done
+	if r.err != nil {
+		return nil, r.err
+	}
+	if limit > 0 && len(r.msgs) > limit {
+		r.msgs = r.msgs[len(r.msgs)-limit:]
+	}
+	return r.msgs, nil
+}
+
+func (s *indexedDBHistoryStore) Purge(before time.Time) error {
+	db, err := s.openDB()
+	if err != nil {
+		return err
+	}
+	tx := db.Call("transaction", []interface{}{indexedDBStoreName}, "readwrite")
+	store := tx.Call("objectStore", indexedDBStoreName)
+	req := store.Call("openCursor")
+
+	done := make(chan error, 1)
+	var onsuccess, onerror js.Func
+	onsuccess = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		cursor := req.Get("result")
+		if cursor.IsNull() {
+			onsuccess.Release()
+			onerror.Release()
+			done <- nil
+			return nil
+		}
+		row := cursor.Get("value")
+		if messageFromJS(row).Timestamp.Before(before) {
+			cursor.Call("delete")
+		}
+		cursor.Call("continue")
+		return nil
+	})
+	onerror = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		onsuccess.Release()
+		onerror.Release()
+		done <- fmt.Errorf("history: indexedDB cursor: %s", req.Get("error").Call("toString").String())
+		return nil
+	})
+	req.Set("onsuccess", onsuccess)
+	req.Set("onerror", onerror)
+
+	return <-done
+}
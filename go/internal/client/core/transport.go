@@ -0,0 +1,88 @@
+// tincan/internal/client/core/transport.go
+package core
+
+import "fmt"
+
+// Transport abstracts the wire connection a native ClientCore reads and
+// writes newline-delimited protocol lines over, so TCP and WebSocket can
+// share one Connect/processIncomingMessages implementation (see
+// connect_native.go) instead of each platform/protocol combination
+// needing its own copy. The WASM build keeps its own event-driven path
+// (see connect_wasm.go) rather than being forced through this interface
+// - a blocking ReadLine doesn't fit a browser's callback-based
+// WebSocket API without a deeper rewrite than this abstraction is for.
+type Transport interface {
+	// Dial establishes the connection to address (host:port).
+	Dial(address string) error
+	// ReadLine blocks for the next complete protocol line, including its
+	// trailing newline, matching the contract bufio.Reader.ReadString('\n')
+	// already established for this package.
+	ReadLine() (string, error)
+	// WriteLine sends line (expected to already end in "\n") as one
+	// message.
+	WriteLine(line string) error
+	// Close releases the underlying connection.
+	Close() error
+}
+
+// TransportKind selects which Transport implementation platformConnect
+// builds for a native Connect call.
+type TransportKind int
+
+const (
+	// TransportTCP dials a raw TCP connection (the original, and still
+	// default, native transport).
+	TransportTCP TransportKind = iota
+	// TransportWS dials a ws:// WebSocket connection.
+	TransportWS
+	// TransportWSS dials a wss:// (TLS) WebSocket connection.
+	TransportWSS
+	// TransportAuto tries TransportWS first and falls back to
+	// TransportTCP if the WebSocket handshake fails, so one binary can
+	// work whether the server is reachable directly or only through an
+	// HTTP reverse proxy that speaks WebSocket.
+	TransportAuto
+)
+
+// String renders k the way it appears in --transport and status messages.
+func (k TransportKind) String() string {
+	switch k {
+	case TransportTCP:
+		return "tcp"
+	case TransportWS:
+		return "ws"
+	case TransportWSS:
+		return "wss"
+	case TransportAuto:
+		return "auto"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTransportKind parses the --transport flag value.
+func ParseTransportKind(s string) (TransportKind, error) {
+	switch s {
+	case "tcp", "":
+		return TransportTCP, nil
+	case "ws":
+		return TransportWS, nil
+	case "wss":
+		return TransportWSS, nil
+	case "auto":
+		return TransportAuto, nil
+	default:
+		return TransportTCP, fmt.Errorf("core: unknown transport kind %q", s)
+	}
+}
+
+// SetTransportKind selects which Transport the next Connect call uses on
+// a native build (see TransportKind). It has no effect on a WASM build,
+// which always speaks WebSocket through the browser's own API. Must be
+// called before Connect; changing it while already connected only takes
+// effect on the next Connect/reconnect.
+func (cc *ClientCore) SetTransportKind(kind TransportKind) {
+	cc.mu.Lock()
+	cc.transportKind = kind
+	cc.mu.Unlock()
+}
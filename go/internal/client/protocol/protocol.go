@@ -0,0 +1,213 @@
+// Package protocol implements tincan's IRCv3-style framed message format,
+// the client-side counterpart to the server's CAP LS/REQ/END negotiation
+// (see internal/server/server.go's negotiateCapabilities). A framed
+// message looks like:
+//
+//	['@' tags SP] [':' source SP] verb *(SP param) [SP ':' trailing] CRLF
+//
+// Tags carry out-of-band metadata (e.g. "msgid", "time"), Source
+// identifies who a relayed message is from, Verb is the command name
+// (e.g. "PRIVMSG", "CAP"), Params are space-separated positional
+// arguments, and Trailing is the final colon-prefixed argument, which may
+// itself contain spaces. Messages are only framed this way once a client
+// has negotiated the "message-tags" capability; otherwise the server and
+// client keep speaking the plain, unframed line protocol this package
+// deliberately has no opinion about.
+package protocol
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// maxTagBytes bounds the tag section of a message (everything between a
+// leading '@' and the space before the rest of the line), matching
+// IRCv3's message-tags limit so a malformed or hostile line can't force
+// unbounded parsing work.
+const maxTagBytes = 8191
+
+// Message is one framed protocol line.
+type Message struct {
+	Tags        map[string]string
+	Source      string
+	Verb        string
+	Params      []string
+	Trailing    string
+	HasTrailing bool
+}
+
+// Parse decodes one framed protocol line. line may include a trailing CR
+// and/or LF; both are stripped before parsing.
+func Parse(line []byte) (Message, error) {
+	s := strings.TrimRight(string(line), "\r\n")
+	var msg Message
+
+	if s == "" {
+		return msg, fmt.Errorf("protocol: empty message")
+	}
+
+	if s[0] == '@' {
+		sp := strings.IndexByte(s, ' ')
+		if sp < 0 {
+			return msg, fmt.Errorf("protocol: tags with no message following")
+		}
+		tagSection := s[1:sp]
+		if len(tagSection) > maxTagBytes {
+			return msg, fmt.Errorf("protocol: tag section exceeds %d bytes", maxTagBytes)
+		}
+		msg.Tags = parseTags(tagSection)
+		s = strings.TrimLeft(s[sp+1:], " ")
+	}
+
+	if strings.HasPrefix(s, ":") {
+		sp := strings.IndexByte(s, ' ')
+		if sp < 0 {
+			return msg, fmt.Errorf("protocol: source with no verb following")
+		}
+		msg.Source = s[1:sp]
+		s = strings.TrimLeft(s[sp+1:], " ")
+	}
+
+	head := s
+	if idx := strings.Index(s, " :"); idx >= 0 {
+		head = s[:idx]
+		msg.Trailing = s[idx+2:]
+		msg.HasTrailing = true
+	}
+
+	tokens := strings.Fields(head)
+	if len(tokens) == 0 {
+		return msg, fmt.Errorf("protocol: message has no verb")
+	}
+	msg.Verb = tokens[0]
+	msg.Params = tokens[1:]
+
+	return msg, nil
+}
+
+// Encode writes m to w in framed wire format, including the trailing
+// CRLF. Tag keys are written in sorted order so Encode is deterministic.
+func (m Message) Encode(w io.Writer) error {
+	var b strings.Builder
+
+	if len(m.Tags) > 0 {
+		keys := make([]string, 0, len(m.Tags))
+		for k := range m.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		tagStart := b.Len()
+		b.WriteByte('@')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(';')
+			}
+			b.WriteString(k)
+			if v := m.Tags[k]; v != "" {
+				b.WriteByte('=')
+				b.WriteString(escapeTagValue(v))
+			}
+		}
+		if b.Len()-tagStart-1 > maxTagBytes {
+			return fmt.Errorf("protocol: encoded tag section exceeds %d bytes", maxTagBytes)
+		}
+		b.WriteByte(' ')
+	}
+
+	if m.Source != "" {
+		b.WriteByte(':')
+		b.WriteString(m.Source)
+		b.WriteByte(' ')
+	}
+
+	b.WriteString(m.Verb)
+	for _, p := range m.Params {
+		b.WriteByte(' ')
+		b.WriteString(p)
+	}
+	if m.HasTrailing {
+		b.WriteString(" :")
+		b.WriteString(m.Trailing)
+	}
+	b.WriteString("\r\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// parseTags decodes a "key[=value][;key[=value]]*" tag section. Malformed
+// pairs (stray semicolons, an '=' with nothing after it) are tolerated
+// rather than rejected outright, the same leniency loadLimitsConfig's
+// line parser shows malformed config lines.
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(s, ";") {
+		if pair == "" {
+			continue
+		}
+		if eq := strings.IndexByte(pair, '='); eq >= 0 {
+			tags[pair[:eq]] = unescapeTagValue(pair[eq+1:])
+		} else {
+			tags[pair] = ""
+		}
+	}
+	return tags
+}
+
+// escapeTagValue applies IRCv3 tag-value escaping: ';', ' ', '\\', '\r',
+// and '\n' all need encoding since they'd otherwise be ambiguous with the
+// tag section's own delimiters or break line framing.
+func escapeTagValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ';':
+			b.WriteString(`\:`)
+		case ' ':
+			b.WriteString(`\s`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// unescapeTagValue reverses escapeTagValue. An unrecognized escape
+// (a backslash followed by anything else) keeps the literal character,
+// matching the IRCv3 spec's guidance to drop the backslash rather than
+// error on it.
+func unescapeTagValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
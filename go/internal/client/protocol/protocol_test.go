@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestTagValueEscapeRoundTrip checks that every byte escapeTagValue treats
+// specially survives a full Parse(Encode(m)) round trip, since Parse relies
+// on unescapeTagValue exactly reversing what Encode's escapeTagValue did.
+func TestTagValueEscapeRoundTrip(t *testing.T) {
+	values := []string{
+		"plain",
+		"has space",
+		"semi;colon",
+		`back\slash`,
+		"carriage\rreturn",
+		"new\nline",
+		"all;of\\ it\r\n together",
+		"",
+	}
+
+	for _, v := range values {
+		msg := Message{
+			Tags:        map[string]string{"example": v},
+			Verb:        "PRIVMSG",
+			Params:      []string{"#general"},
+			Trailing:    "hello",
+			HasTrailing: true,
+		}
+
+		var buf bytes.Buffer
+		if err := msg.Encode(&buf); err != nil {
+			t.Fatalf("Encode(%q): %v", v, err)
+		}
+
+		got, err := Parse(buf.Bytes())
+		if err != nil {
+			t.Fatalf("Parse(%q) after encode: %v", v, err)
+		}
+		if got.Tags["example"] != v {
+			t.Errorf("round trip of %q: got %q", v, got.Tags["example"])
+		}
+	}
+}
+
+// TestParseEncodeMessageShape checks that Parse recovers Source, Verb,
+// Params and Trailing from a line in the same shape Encode produces them.
+func TestParseEncodeMessageShape(t *testing.T) {
+	msg := Message{
+		Source:      "alice",
+		Verb:        "PRIVMSG",
+		Params:      []string{"#general", "extra"},
+		Trailing:    "hello there, world",
+		HasTrailing: true,
+	}
+
+	var buf bytes.Buffer
+	if err := msg.Encode(&buf); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	got, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got.Source != msg.Source || got.Verb != msg.Verb || got.Trailing != msg.Trailing || !got.HasTrailing {
+		t.Fatalf("Parse(Encode(m)) = %+v, want fields matching %+v", got, msg)
+	}
+	if len(got.Params) != len(msg.Params) {
+		t.Fatalf("Params = %v, want %v", got.Params, msg.Params)
+	}
+	for i := range msg.Params {
+		if got.Params[i] != msg.Params[i] {
+			t.Fatalf("Params[%d] = %q, want %q", i, got.Params[i], msg.Params[i])
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// tincan/internal/logging/logging.go
+
+// Package logging implements leveled, structured logging with pluggable
+// output sinks, replacing the scattered log.Printf/fmt.Printf calls that
+// used to mix debug chatter in with user-facing output. A Logger holds a
+// minimum Level and one or more Sinks; Field lets a caller attach
+// structured key-value context to a line instead of folding it into the
+// message string.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level orders log severity from most to least verbose.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String renders l the way it appears in a log line and in --log-level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseLevel parses the --log-level flag value ("debug", "info", "warn",
+// "error", case-insensitive). An unrecognized value is an error rather
+// than silently falling back, so a typo in a deployment's flags is caught
+// at startup instead of quietly dropping logs.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("logging: unknown level %q", s)
+	}
+}
+
+// Field is one piece of structured context attached to a log line.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F builds a Field, e.g. logging.F("context", "handleServerMessage").
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Entry is one fully-formed log line, handed to every Sink.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Sink receives every Entry a Logger accepts (i.e. at or above its
+// Level). Write should not retain e.Fields past the call: it is the
+// caller's variadic slice, passed through without a copy. A failing Sink is not
+// fatal to the Logger - see Logger.log.
+type Sink interface {
+	Write(e Entry) error
+}
+
+// Logger is a leveled logger that fans each accepted Entry out to every
+// configured Sink. It is safe for concurrent use.
+type Logger struct {
+	mu    sync.Mutex
+	level Level
+	sinks []Sink
+}
+
+// New creates a Logger at level, writing to sinks. A Logger with no
+// sinks accepts entries but discards them, which is occasionally useful
+// as a test/no-op default.
+func New(level Level, sinks ...Sink) *Logger {
+	return &Logger{level: level, sinks: sinks}
+}
+
+// SetLevel changes the minimum level accepted going forward.
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	l.level = level
+	l.mu.Unlock()
+}
+
+// AddSink appends an additional output sink.
+func (l *Logger) AddSink(s Sink) {
+	l.mu.Lock()
+	l.sinks = append(l.sinks, s)
+	l.mu.Unlock()
+}
+
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	l.mu.Lock()
+	if level < l.level {
+		l.mu.Unlock()
+		return
+	}
+	sinks := l.sinks
+	l.mu.Unlock()
+
+	e := Entry{Time: time.Now(), Level: level, Message: msg, Fields: fields}
+	for _, s := range sinks {
+		// A sink failing to write (e.g. a full disk) shouldn't stop the
+		// others from getting the line or bring down the caller; there's
+		// nowhere better to report it than stderr.
+		if err := s.Write(e); err != nil {
+			fmt.Fprintf(os.Stderr, "logging: sink write failed: %v\n", err)
+		}
+	}
+}
+
+// Debug logs a low-level diagnostic line, typically only enabled during
+// development or active troubleshooting.
+func (l *Logger) Debug(msg string, fields ...Field) { l.log(LevelDebug, msg, fields) }
+
+// Info logs a normal operational event.
+func (l *Logger) Info(msg string, fields ...Field) { l.log(LevelInfo, msg, fields) }
+
+// Warn logs something unexpected but recoverable.
+func (l *Logger) Warn(msg string, fields ...Field) { l.log(LevelWarn, msg, fields) }
+
+// Error logs a failure worth operator attention.
+func (l *Logger) Error(msg string, fields ...Field) { l.log(LevelError, msg, fields) }
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger = New(LevelInfo, NewConsoleSink(os.Stderr))
+)
+
+// SetDefault replaces the package-level default Logger used by
+// Debug/Info/Warn/Error. Callers that want a single shared logger
+// (tincan-cli, tincan-server) call this once at startup after parsing
+// their --log-* flags.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defaultLogger = l
+	defaultMu.Unlock()
+}
+
+// Default returns the current package-level default Logger.
+func Default() *Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	return defaultLogger
+}
+
+// Debug logs to the default Logger. See Logger.Debug.
+func Debug(msg string, fields ...Field) { Default().Debug(msg, fields...) }
+
+// Info logs to the default Logger. See Logger.Info.
+func Info(msg string, fields ...Field) { Default().Info(msg, fields...) }
+
+// Warn logs to the default Logger. See Logger.Warn.
+func Warn(msg string, fields ...Field) { Default().Warn(msg, fields...) }
+
+// Error logs to the default Logger. See Logger.Error.
+func Error(msg string, fields ...Field) { Default().Error(msg, fields...) }
+
+// Writer adapts a Logger to io.Writer, so code still writing through the
+// standard library's log package (e.g. a server package this change
+// doesn't otherwise touch) can be pointed at the same sinks via
+// log.SetOutput, at a single fixed Level since stdlib log has no notion
+// of severity.
+type Writer struct {
+	logger *Logger
+	level  Level
+}
+
+// NewWriter creates a Writer that logs each Write at level.
+func NewWriter(logger *Logger, level Level) *Writer {
+	return &Writer{logger: logger, level: level}
+}
+
+// Write implements io.Writer. p is logged as a single Entry with its
+// trailing newline (added by the standard log package) stripped.
+func (w *Writer) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	w.logger.log(w.level, msg, nil)
+	return len(p), nil
+}
@@ -0,0 +1,230 @@
+// tincan/internal/logging/sinks.go
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsoleSink writes human-readable text lines, e.g.:
+//
+//	2026-07-29T12:00:00Z INFO  Starting Tincan server... port=8080
+type ConsoleSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewConsoleSink creates a ConsoleSink writing to w.
+func NewConsoleSink(w io.Writer) *ConsoleSink {
+	return &ConsoleSink{w: w}
+}
+
+// Write implements Sink.
+func (s *ConsoleSink) Write(e Entry) error {
+	var b strings.Builder
+	b.WriteString(e.Time.UTC().Format(time.RFC3339))
+	b.WriteByte(' ')
+	fmt.Fprintf(&b, "%-5s", e.Level.String())
+	b.WriteByte(' ')
+	b.WriteString(e.Message)
+	for _, f := range e.Fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	b.WriteByte('\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, b.String())
+	return err
+}
+
+// JSONSink writes one JSON object per line, for log shippers/aggregators
+// that expect structured input rather than text to pattern-match.
+type JSONSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSink creates a JSONSink writing to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+type jsonEntry struct {
+	Time    string                 `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"msg"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Write implements Sink.
+func (s *JSONSink) Write(e Entry) error {
+	je := jsonEntry{
+		Time:    e.Time.UTC().Format(time.RFC3339Nano),
+		Level:   e.Level.String(),
+		Message: e.Message,
+	}
+	if len(e.Fields) > 0 {
+		je.Fields = make(map[string]interface{}, len(e.Fields))
+		for _, f := range e.Fields {
+			je.Fields[f.Key] = f.Value
+		}
+	}
+
+	line, err := json.Marshal(je)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(line)
+	return err
+}
+
+// RotatingFileSink is a ConsoleSink- or JSONSink-formatted sink that rotates its
+// output file once it exceeds maxSizeBytes, keeping at most maxBackups
+// old files (path.1, path.2, ...) and pruning any backup older than
+// maxAge on each rotation. maxSizeBytes <= 0 disables size-based
+// rotation; maxAge <= 0 disables age-based pruning.
+type RotatingFileSink struct {
+	mu           sync.Mutex
+	path         string
+	maxSizeBytes int64
+	maxBackups   int
+	maxAge       time.Duration
+	json         bool
+
+	file *os.File
+	size int64
+}
+
+// NewRotatingFileSink opens (creating if necessary) path for appending
+// and returns a Sink that rotates it according to maxSizeBytes,
+// maxBackups, and maxAge. asJSON selects JSONSink-style lines instead of
+// ConsoleSink-style text, so --log-format applies to file output too.
+func NewRotatingFileSink(path string, maxSizeBytes int64, maxBackups int, maxAge time.Duration, asJSON bool) (*RotatingFileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("logging: open %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("logging: stat %s: %w", path, err)
+	}
+
+	return &RotatingFileSink{
+		path:         path,
+		maxSizeBytes: maxSizeBytes,
+		maxBackups:   maxBackups,
+		maxAge:       maxAge,
+		json:         asJSON,
+		file:         f,
+		size:         info.Size(),
+	}, nil
+}
+
+// Write implements Sink.
+func (s *RotatingFileSink) Write(e Entry) error {
+	line, err := s.format(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSizeBytes > 0 && s.size+int64(len(line)) > s.maxSizeBytes {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *RotatingFileSink) format(e Entry) ([]byte, error) {
+	if s.json {
+		sink := JSONSink{}
+		var buf strings.Builder
+		sink.w = &buf
+		if err := sink.Write(e); err != nil {
+			return nil, err
+		}
+		return []byte(buf.String()), nil
+	}
+
+	sink := ConsoleSink{}
+	var buf strings.Builder
+	sink.w = &buf
+	if err := sink.Write(e); err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
+
+// rotateLocked renames the current file to path.1 (shifting existing
+// path.1..path.N-1 up by one, dropping anything past maxBackups), prunes
+// backups older than maxAge, and opens a fresh file at path. Caller must
+// hold s.mu.
+func (s *RotatingFileSink) rotateLocked() error {
+	s.file.Close()
+
+	if s.maxBackups > 0 {
+		oldest := fmt.Sprintf("%s.%d", s.path, s.maxBackups)
+		os.Remove(oldest)
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			from := fmt.Sprintf("%s.%d", s.path, i)
+			to := fmt.Sprintf("%s.%d", s.path, i+1)
+			os.Rename(from, to)
+		}
+		os.Rename(s.path, s.path+".1")
+	} else {
+		os.Remove(s.path)
+	}
+
+	if s.maxAge > 0 {
+		s.pruneAgedBackups()
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("logging: reopen %s after rotation: %w", s.path, err)
+	}
+	s.file = f
+	s.size = 0
+	return nil
+}
+
+// pruneAgedBackups removes any path.N backup whose mtime is older than
+// maxAge. Caller must hold s.mu.
+func (s *RotatingFileSink) pruneAgedBackups() {
+	cutoff := time.Now().Add(-s.maxAge)
+	for i := 1; i <= s.maxBackups; i++ {
+		name := fmt.Sprintf("%s.%d", s.path, i)
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			os.Remove(name)
+		}
+	}
+}
+
+// Close closes the underlying file.
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
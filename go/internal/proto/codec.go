@@ -0,0 +1,42 @@
+// tincan/internal/proto/codec.go
+package proto
+
+import "fmt"
+
+// Subprotocol* are the WebSocket subprotocol strings a connection
+// negotiates to pick a Codec (see ClientCore.SetCodec). The native TCP
+// transport sends neither and always speaks the text protocol.
+// SubprotocolText is the default and the only one any server in this
+// snapshot actually understands; SubprotocolProto is reserved for a
+// server that's been upgraded to also run ProtoCodec.
+const (
+	SubprotocolText  = "tincan.v1.text"
+	SubprotocolProto = "tincan.v1.proto"
+)
+
+// Codec turns a Frame into wire bytes and back. TextCodec and ProtoCodec
+// are the two implementations a connection can negotiate (see
+// ClientCore.SetCodec); both are safe for concurrent use since they hold
+// no state of their own.
+type Codec interface {
+	// EncodeFrame returns f's wire representation, ready to write to the
+	// transport (a single WriteLine for TextCodec, or a length-prefixed
+	// binary message for ProtoCodec).
+	EncodeFrame(f Frame) ([]byte, error)
+	// DecodeFrame parses one wire message back into a Frame.
+	DecodeFrame(data []byte) (Frame, error)
+	// Subprotocol is the negotiated name this Codec corresponds to.
+	Subprotocol() string
+}
+
+// CodecFor resolves a negotiated subprotocol string to its Codec.
+func CodecFor(subprotocol string) (Codec, error) {
+	switch subprotocol {
+	case SubprotocolText, "":
+		return TextCodec{}, nil
+	case SubprotocolProto:
+		return ProtoCodec{}, nil
+	default:
+		return nil, fmt.Errorf("proto: unknown subprotocol %q", subprotocol)
+	}
+}
@@ -0,0 +1,124 @@
+// tincan/internal/proto/frame.go
+
+// Package proto defines tincan's binary frame schema (see frame.proto)
+// and the Codec interface that turns a Frame into wire bytes and back,
+// so a connection can negotiate either the original newline-delimited
+// text protocol or this richer framed one via a subprotocol string (see
+// ClientCore.SetCodec) without either side needing to know which one
+// the other started out speaking.
+//
+// The Go types below are hand-written to mirror what protoc-gen-go
+// would generate from frame.proto's oneof messages (a FrameKind enum
+// plus one pointer field per oneof case, exactly one non-nil). This
+// repo snapshot has no go.mod and no vendored google.golang.org/protobuf,
+// so ProtoCodec (see protocodec.go) encodes these types with a small
+// hand-rolled length-prefixed binary format instead of real protobuf
+// wire format; frame.proto is still the source of truth for the schema,
+// and swapping ProtoCodec's body for protoc-gen-go output plus
+// google.golang.org/protobuf/proto.Marshal/Unmarshal is a drop-in
+// replacement once the module has that dependency available.
+package proto
+
+import "time"
+
+// FrameKind identifies which oneof case a Frame carries.
+type FrameKind int
+
+const (
+	FrameLogin FrameKind = iota
+	FrameGlobal
+	FrameDM
+	FrameGM
+	FramePresence
+	FrameAck
+	FramePing
+)
+
+// String renders k for logging/debugging.
+func (k FrameKind) String() string {
+	switch k {
+	case FrameLogin:
+		return "login"
+	case FrameGlobal:
+		return "global"
+	case FrameDM:
+		return "dm"
+	case FrameGM:
+		return "gm"
+	case FramePresence:
+		return "presence"
+	case FrameAck:
+		return "ack"
+	case FramePing:
+		return "ping"
+	default:
+		return "unknown"
+	}
+}
+
+// Frame is the decoded form of either a ClientFrame or a ServerFrame
+// (frame.proto uses two message types so the server never has to
+// reject a case the client isn't allowed to send, but both decode into
+// this same Go struct since nothing here stops a program from building
+// a Frame directly for either direction).
+type Frame struct {
+	Kind FrameKind
+
+	Login    *LoginPayload
+	Global   *GlobalPayload
+	DM       *DMPayload
+	GM       *GMPayload
+	Presence *PresencePayload
+	Ack      *AckPayload
+	Ping     *PingPayload
+}
+
+// LoginPayload mirrors frame.proto's LoginFrame.
+type LoginPayload struct {
+	Username    string
+	ResumeToken string
+}
+
+// GlobalPayload mirrors frame.proto's GlobalFrame.
+type GlobalPayload struct {
+	ID        uint64
+	From      string
+	Text      string
+	Timestamp time.Time
+}
+
+// DMPayload mirrors frame.proto's DMFrame.
+type DMPayload struct {
+	ID        uint64
+	From      string
+	To        string
+	Text      string
+	Timestamp time.Time
+}
+
+// GMPayload mirrors frame.proto's GMFrame.
+type GMPayload struct {
+	ID        uint64
+	From      string
+	Group     string
+	Text      string
+	Timestamp time.Time
+}
+
+// PresencePayload mirrors frame.proto's PresenceFrame.
+type PresencePayload struct {
+	Username string
+	Online   bool
+}
+
+// AckPayload mirrors frame.proto's AckFrame: a delivery receipt for the
+// message with the given ID (see internal/server/history.Entry.ID),
+// something the line protocol has no equivalent of today.
+type AckPayload struct {
+	ID uint64
+}
+
+// PingPayload mirrors frame.proto's PingFrame; it carries no fields,
+// playing the same keepalive role as the "PING"/"PONG" text lines (see
+// ClientCore.SetKeepalive) but as a frame instead of a bare line.
+type PingPayload struct{}
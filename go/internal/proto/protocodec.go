@@ -0,0 +1,231 @@
+// tincan/internal/proto/protocodec.go
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ProtoCodec encodes a Frame as a compact binary message: one kind byte
+// followed by that kind's fields in frame.proto's field order - uvarints
+// and uvarint-length-prefixed strings via encoding/binary, timestamps as
+// 8-byte big-endian int64 milliseconds, bools as one byte. The message as
+// a whole carries no length prefix of its own.
+// This stands in for real protobuf wire format (see the package doc in
+// frame.go for why) - the frame.proto schema is authoritative, and this
+// encoding exists only so EncodeFrame/DecodeFrame round-trip correctly
+// without a generated-code or third-party dependency this snapshot
+// doesn't have. No transport in this tree calls EncodeFrame or
+// DecodeFrame: the native and WASM clients and the server all exchange
+// newline-terminated text lines, and the server's WebSocket transport
+// rejects binary messages. EncodeFrame's output has no trailing newline
+// the way TextCodec's does.
+type ProtoCodec struct{}
+
+func (ProtoCodec) Subprotocol() string { return SubprotocolProto }
+
+func (ProtoCodec) EncodeFrame(f Frame) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte(byte(f.Kind))
+
+	switch f.Kind {
+	case FrameLogin:
+		if f.Login == nil {
+			return nil, fmt.Errorf("proto: FrameLogin with nil Login payload")
+		}
+		writeString(&b, f.Login.Username)
+		writeString(&b, f.Login.ResumeToken)
+	case FrameGlobal:
+		if f.Global == nil {
+			return nil, fmt.Errorf("proto: FrameGlobal with nil Global payload")
+		}
+		writeUvarint(&b, f.Global.ID)
+		writeString(&b, f.Global.From)
+		writeString(&b, f.Global.Text)
+		writeInt64(&b, formatUnixMs(f.Global.Timestamp))
+	case FrameDM:
+		if f.DM == nil {
+			return nil, fmt.Errorf("proto: FrameDM with nil DM payload")
+		}
+		writeUvarint(&b, f.DM.ID)
+		writeString(&b, f.DM.From)
+		writeString(&b, f.DM.To)
+		writeString(&b, f.DM.Text)
+		writeInt64(&b, formatUnixMs(f.DM.Timestamp))
+	case FrameGM:
+		if f.GM == nil {
+			return nil, fmt.Errorf("proto: FrameGM with nil GM payload")
+		}
+		writeUvarint(&b, f.GM.ID)
+		writeString(&b, f.GM.From)
+		writeString(&b, f.GM.Group)
+		writeString(&b, f.GM.Text)
+		writeInt64(&b, formatUnixMs(f.GM.Timestamp))
+	case FramePresence:
+		if f.Presence == nil {
+			return nil, fmt.Errorf("proto: FramePresence with nil Presence payload")
+		}
+		writeString(&b, f.Presence.Username)
+		if f.Presence.Online {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case FrameAck:
+		if f.Ack == nil {
+			return nil, fmt.Errorf("proto: FrameAck with nil Ack payload")
+		}
+		writeUvarint(&b, f.Ack.ID)
+	case FramePing:
+		// No fields.
+	default:
+		return nil, fmt.Errorf("proto: ProtoCodec cannot encode frame kind %d", f.Kind)
+	}
+
+	return b.Bytes(), nil
+}
+
+func (ProtoCodec) DecodeFrame(data []byte) (Frame, error) {
+	if len(data) == 0 {
+		return Frame{}, fmt.Errorf("proto: empty frame")
+	}
+	r := bytes.NewReader(data[1:])
+	kind := FrameKind(data[0])
+
+	switch kind {
+	case FrameLogin:
+		username, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		token, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		return Frame{Kind: kind, Login: &LoginPayload{Username: username, ResumeToken: token}}, nil
+	case FrameGlobal:
+		id, err := readUvarint(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		from, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		text, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		ts, err := readInt64(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		return Frame{Kind: kind, Global: &GlobalPayload{ID: id, From: from, Text: text, Timestamp: parseUnixMs(ts)}}, nil
+	case FrameDM:
+		id, err := readUvarint(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		from, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		to, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		text, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		ts, err := readInt64(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		return Frame{Kind: kind, DM: &DMPayload{ID: id, From: from, To: to, Text: text, Timestamp: parseUnixMs(ts)}}, nil
+	case FrameGM:
+		id, err := readUvarint(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		from, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		group, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		text, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		ts, err := readInt64(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		return Frame{Kind: kind, GM: &GMPayload{ID: id, From: from, Group: group, Text: text, Timestamp: parseUnixMs(ts)}}, nil
+	case FramePresence:
+		username, err := readString(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		onlineByte, err := r.ReadByte()
+		if err != nil {
+			return Frame{}, err
+		}
+		return Frame{Kind: kind, Presence: &PresencePayload{Username: username, Online: onlineByte != 0}}, nil
+	case FrameAck:
+		id, err := readUvarint(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		return Frame{Kind: kind, Ack: &AckPayload{ID: id}}, nil
+	case FramePing:
+		return Frame{Kind: kind, Ping: &PingPayload{}}, nil
+	default:
+		return Frame{}, fmt.Errorf("proto: unknown frame kind %d", data[0])
+	}
+}
+
+func writeUvarint(b *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	b.Write(tmp[:n])
+}
+
+func readUvarint(r *bytes.Reader) (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func writeInt64(b *bytes.Buffer, v int64) {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], uint64(v))
+	b.Write(tmp[:])
+}
+
+func readInt64(r *bytes.Reader) (int64, error) {
+	var tmp [8]byte
+	if _, err := r.Read(tmp[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(tmp[:])), nil
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	writeUvarint(b, uint64(len(s)))
+	b.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := r.Read(buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
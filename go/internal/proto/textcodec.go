@@ -0,0 +1,77 @@
+// tincan/internal/proto/textcodec.go
+package proto
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TextCodec reproduces the server's existing plain-line protocol
+// (PRIVMSG/GROUPMSG/PING and friends - see internal/server/server.go's
+// runSession) as a Codec, so it can sit behind the same interface as
+// ProtoCodec and be the thing CodecFor returns for SubprotocolText (the
+// default every server in this snapshot already speaks). It only covers
+// the handful of Frame kinds that already have a line form; anything
+// else is a caller error, same as ProtoCodec's unknown-kind case.
+type TextCodec struct{}
+
+func (TextCodec) Subprotocol() string { return SubprotocolText }
+
+func (TextCodec) EncodeFrame(f Frame) ([]byte, error) {
+	var line string
+	switch f.Kind {
+	case FrameGlobal:
+		line = f.Global.Text
+	case FrameDM:
+		line = fmt.Sprintf("PRIVMSG %s %s", f.DM.To, f.DM.Text)
+	case FrameGM:
+		line = fmt.Sprintf("GROUPMSG %s %s", f.GM.Group, f.GM.Text)
+	case FramePing:
+		line = "PING"
+	default:
+		return nil, fmt.Errorf("proto: TextCodec cannot encode frame kind %s", f.Kind)
+	}
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	return []byte(line), nil
+}
+
+func (TextCodec) DecodeFrame(data []byte) (Frame, error) {
+	line := strings.TrimRight(string(data), "\r\n")
+	switch {
+	case line == "PING" || line == "PONG":
+		return Frame{Kind: FramePing, Ping: &PingPayload{}}, nil
+	case strings.HasPrefix(line, "PRIVMSG "):
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) < 3 {
+			return Frame{}, fmt.Errorf("proto: malformed PRIVMSG line")
+		}
+		return Frame{Kind: FrameDM, DM: &DMPayload{To: parts[1], Text: parts[2]}}, nil
+	case strings.HasPrefix(line, "GROUPMSG "):
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) < 3 {
+			return Frame{}, fmt.Errorf("proto: malformed GROUPMSG line")
+		}
+		return Frame{Kind: FrameGM, GM: &GMPayload{Group: parts[1], Text: parts[2]}}, nil
+	default:
+		return Frame{Kind: FrameGlobal, Global: &GlobalPayload{Text: line}}, nil
+	}
+}
+
+// formatUnixMs and parseUnixMs are shared with ProtoCodec for the
+// timestamp fields frame.proto carries as int64 milliseconds.
+func formatUnixMs(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func parseUnixMs(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,109 @@
+// tincan/internal/ratelimit/ratelimit.go
+
+// Package ratelimit implements a token-bucket flood limiter with per-verb
+// costs. It's shared between internal/server (which applies it per
+// connection) and internal/client/core (which mirrors the same costs so
+// the client can hold a message back and queue it instead of finding out
+// only after the server rejects it with FAIL RATELIMIT).
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCosts assigns a token cost to each verb this protocol carries,
+// roughly reflecting how expensive it is to service: a PRIVMSG touches
+// one recipient, a GROUPMSG fans out to a whole group, and a HISTORY
+// query scans backlog. A verb with no entry here costs DefaultCost, the
+// same as PRIVMSG, so new commands aren't accidentally free.
+var DefaultCosts = map[string]float64{
+	"GLOBAL":      1,
+	"PRIVMSG":     1,
+	"GROUPMSG":    2,
+	"HISTORY":     5,
+	"CHATHISTORY": 5,
+	"PING":        0.1,
+}
+
+// DefaultCost is charged for any verb not listed in a Limiter's cost
+// table.
+const DefaultCost = 1
+
+// Limiter is a token-bucket flood limiter: burst tokens refill steadily
+// over window, and Allow draws down the bucket by a verb-specific amount
+// rather than a flat 1 per call. Exhausting the bucket doesn't block the
+// caller - Allow reports how long to wait instead, so both a blocking
+// server dispatch loop and a client-side queueing sender can decide what
+// to do with that.
+type Limiter struct {
+	mu         sync.Mutex
+	burst      float64
+	window     time.Duration
+	tokens     float64
+	lastRefill time.Time
+	costs      map[string]float64
+}
+
+// NewLimiter creates a Limiter with a full bucket. costs maps verb to
+// token cost; a nil costs falls back to DefaultCosts.
+func NewLimiter(window time.Duration, burst float64, costs map[string]float64) *Limiter {
+	if costs == nil {
+		costs = DefaultCosts
+	}
+	return &Limiter{
+		burst:      burst,
+		window:     window,
+		tokens:     burst,
+		lastRefill: time.Now(),
+		costs:      costs,
+	}
+}
+
+// costFor returns verb's configured cost, or DefaultCost if unlisted.
+func (l *Limiter) costFor(verb string) float64 {
+	if c, ok := l.costs[verb]; ok {
+		return c
+	}
+	return DefaultCost
+}
+
+// Allow reports whether verb may proceed right now. When it can't, the
+// returned duration is how long the caller should wait before the bucket
+// will have refilled enough to afford it - not a guarantee, since other
+// traffic can spend those tokens first, but enough for a retry hint or a
+// queue delay.
+func (l *Limiter) Allow(verb string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	refillRate := 0.0
+	if l.window > 0 {
+		refillRate = l.burst / l.window.Seconds()
+		elapsed := now.Sub(l.lastRefill)
+		l.tokens += elapsed.Seconds() * refillRate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.lastRefill = now
+
+	cost := l.costFor(verb)
+	if l.tokens < cost {
+		if refillRate <= 0 {
+			return false, 0
+		}
+		deficit := cost - l.tokens
+		return false, time.Duration(deficit / refillRate * float64(time.Second))
+	}
+	l.tokens -= cost
+	return true, 0
+}
+
+// Snapshot returns the current token count, for status/debug reporting.
+func (l *Limiter) Snapshot() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tokens
+}
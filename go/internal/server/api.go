@@ -0,0 +1,84 @@
+// tincan/internal/server/api.go
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const (
+	defaultAPIHistoryLimit = 50
+	maxAPIHistoryLimit     = 500
+)
+
+// handleAPIHistory returns the last `limit` lines (default 50, capped at
+// 500) of CHAT_LOG_FILE as JSON, for a web client that wants scrollback
+// without opening a full session - e.g. a read-only status page.
+//
+// CHAT_LOG_FILE is logChatMessage's append-only record of every DM, group
+// message and global line that has ever crossed the server, so this is
+// gated behind the same bearer token /ws and the TCP AUTH path accept
+// (see bearerTokenFromRequest/resolveBearerToken in jwt.go) - without that,
+// any unauthenticated visitor could read private DM content off this
+// endpoint.
+func handleAPIHistory(w http.ResponseWriter, r *http.Request) {
+	if _, _, _, ok := resolveBearerToken(bearerTokenFromRequest(r)); !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := defaultAPIHistoryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	if limit > maxAPIHistoryLimit {
+		limit = maxAPIHistoryLimit
+	}
+
+	lines, err := tailLines(CHAT_LOG_FILE, limit)
+	if err != nil {
+		log.Printf("Error reading %s for /api/history: %v", CHAT_LOG_FILE, err)
+		http.Error(w, "could not read chat log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Lines []string `json:"lines"`
+	}{Lines: lines}); err != nil {
+		log.Printf("Error encoding /api/history response: %v", err)
+	}
+}
+
+// tailLines reads every line of path and returns at most the last n of
+// them. CHAT_LOG_FILE is append-only and expected to stay small enough
+// for this (it's rotated externally, the same assumption logChatMessage
+// already makes by appending to it unbounded), so a full scan is simpler
+// than seeking from the end.
+func tailLines(path string, n int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var all []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		all = append(all, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+	return all, nil
+}
@@ -0,0 +1,113 @@
+// Package history implements per-user and per-group message history for the
+// tincan server, modeled loosely on the replay semantics of IRCv3
+// draft/resume-0.2 as implemented by ergo and oragono: instead of a single
+// global scrollback, each user and each group keeps its own bounded ring of
+// recent messages so a reconnecting client can be shown exactly what it
+// missed rather than a generic tail of everything.
+package history
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind identifies which delivery path produced an Entry.
+type Kind string
+
+const (
+	KindGlobal Kind = "global"
+	KindDM     Kind = "dm"
+	KindGroup  Kind = "group"
+)
+
+// DefaultCapacity is the number of entries retained per buffer before the
+// oldest ones are evicted.
+const DefaultCapacity = 1024
+
+// Entry is a single historical message.
+type Entry struct {
+	ID        uint64 // monotonically increasing across all buffers; see Manager.nextID
+	Timestamp time.Time
+	From      string
+	Target    string // username for DM, group name for group, "" for global
+	Kind      Kind
+	Text      string
+}
+
+// Buffer is a fixed-capacity ring of Entry values. It is safe for
+// concurrent use.
+type Buffer struct {
+	mu       sync.Mutex
+	capacity int
+	entries  []Entry
+	start    int // index of the oldest entry in entries
+	count    int
+}
+
+// NewBuffer creates a Buffer that retains at most capacity entries.
+func NewBuffer(capacity int) *Buffer {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Buffer{
+		capacity: capacity,
+		entries:  make([]Entry, capacity),
+	}
+}
+
+// Add appends an entry, evicting the oldest one if the buffer is full.
+func (b *Buffer) Add(e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.count < b.capacity {
+		b.entries[(b.start+b.count)%b.capacity] = e
+		b.count++
+	} else {
+		b.entries[b.start] = e
+		b.start = (b.start + 1) % b.capacity
+	}
+}
+
+// All returns a copy of the buffered entries in chronological order.
+func (b *Buffer) All() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make([]Entry, b.count)
+	for i := 0; i < b.count; i++ {
+		out[i] = b.entries[(b.start+i)%b.capacity]
+	}
+	return out
+}
+
+// Between returns buffered entries with since < Timestamp <= until, in
+// chronological order. A zero until means "no upper bound".
+func (b *Buffer) Between(since, until time.Time) []Entry {
+	all := b.All()
+	out := make([]Entry, 0, len(all))
+	for _, e := range all {
+		if !e.Timestamp.After(since) {
+			continue
+		}
+		if !until.IsZero() && e.Timestamp.After(until) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// Tail returns up to n of the most recent entries, in chronological order.
+func (b *Buffer) Tail(n int) []Entry {
+	return tailEntries(b.All(), n)
+}
+
+// tailEntries returns up to the last n of entries, in the order they were
+// given. n <= 0 or n >= len(entries) returns entries unchanged.
+func tailEntries(entries []Entry, n int) []Entry {
+	if n <= 0 || n >= len(entries) {
+		return entries
+	}
+	return entries[len(entries)-n:]
+}
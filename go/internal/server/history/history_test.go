@@ -0,0 +1,161 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBufferWrapAround(t *testing.T) {
+	b := NewBuffer(3)
+	base := time.Now()
+	for i := 0; i < 5; i++ {
+		b.Add(Entry{ID: uint64(i), Timestamp: base.Add(time.Duration(i) * time.Second), Text: "msg"})
+	}
+
+	all := b.All()
+	if len(all) != 3 {
+		t.Fatalf("All() returned %d entries, want 3 (capacity)", len(all))
+	}
+	// Oldest two (ID 0, 1) should have been evicted; only 2, 3, 4 remain,
+	// in chronological order.
+	for i, wantID := range []uint64{2, 3, 4} {
+		if all[i].ID != wantID {
+			t.Errorf("All()[%d].ID = %d, want %d", i, all[i].ID, wantID)
+		}
+	}
+}
+
+func TestBufferTail(t *testing.T) {
+	b := NewBuffer(10)
+	base := time.Now()
+	for i := 0; i < 4; i++ {
+		b.Add(Entry{ID: uint64(i), Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+
+	tail := b.Tail(2)
+	if len(tail) != 2 || tail[0].ID != 2 || tail[1].ID != 3 {
+		t.Fatalf("Tail(2) = %+v, want entries with ID 2 and 3", tail)
+	}
+
+	if full := b.Tail(0); len(full) != 4 {
+		t.Fatalf("Tail(0) = %d entries, want all 4", len(full))
+	}
+}
+
+func TestBufferBetweenSinceFiltering(t *testing.T) {
+	b := NewBuffer(10)
+	base := time.Now()
+	for i := 0; i < 4; i++ {
+		b.Add(Entry{ID: uint64(i), Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+
+	since := base.Add(1500 * time.Millisecond)
+	got := b.Between(since, time.Time{})
+	if len(got) != 2 || got[0].ID != 2 || got[1].ID != 3 {
+		t.Fatalf("Between(since, zero) = %+v, want entries with ID 2 and 3", got)
+	}
+}
+
+type fakeStore struct {
+	lastSeen map[string]time.Time
+}
+
+func (f *fakeStore) LoadLastSeen() (map[string]time.Time, error) {
+	return f.lastSeen, nil
+}
+
+func (f *fakeStore) SaveLastSeen(lastSeen map[string]time.Time) error {
+	f.lastSeen = lastSeen
+	return nil
+}
+
+// TestReplayForSinceFiltering checks that ReplayFor only returns global, DM
+// and group entries newer than the caller's lastSeen, merged and sorted.
+func TestReplayForSinceFiltering(t *testing.T) {
+	m := NewManager(&fakeStore{lastSeen: make(map[string]time.Time)}, 10)
+	base := time.Now()
+
+	m.global.Add(Entry{ID: 1, Timestamp: base, Kind: KindGlobal, Text: "old global"})
+	m.global.Add(Entry{ID: 2, Timestamp: base.Add(2 * time.Second), Kind: KindGlobal, Text: "new global"})
+
+	m.bufferFor(m.userBufs, "alice").Add(Entry{ID: 3, Timestamp: base, Kind: KindDM, Target: "alice", Text: "old dm"})
+	m.bufferFor(m.userBufs, "alice").Add(Entry{ID: 4, Timestamp: base.Add(3 * time.Second), Kind: KindDM, Target: "alice", Text: "new dm"})
+
+	m.bufferFor(m.groupBufs, "team").Add(Entry{ID: 5, Timestamp: base, Kind: KindGroup, Target: "team", Text: "old group"})
+	m.bufferFor(m.groupBufs, "team").Add(Entry{ID: 6, Timestamp: base.Add(4 * time.Second), Kind: KindGroup, Target: "team", Text: "new group"})
+
+	m.mu.Lock()
+	m.lastSeen["alice"] = base.Add(1 * time.Second)
+	m.mu.Unlock()
+
+	got := m.ReplayFor("alice", []string{"team"})
+	if len(got) != 3 {
+		t.Fatalf("ReplayFor returned %d entries, want 3 (only the ones after lastSeen): %+v", len(got), got)
+	}
+	for _, e := range got {
+		if e.ID == 1 || e.ID == 3 || e.ID == 5 {
+			t.Errorf("ReplayFor included entry %d, which predates lastSeen", e.ID)
+		}
+	}
+}
+
+// TestHistoryCannotReadAnotherUsersDMs checks that History only ever
+// resolves the requester's own DM buffer: a HISTORY <othername> request
+// must not leak othername's inbox just because a buffer exists for it.
+func TestHistoryCannotReadAnotherUsersDMs(t *testing.T) {
+	m := NewManager(&fakeStore{lastSeen: make(map[string]time.Time)}, 10)
+	m.RecordDM("mallory", "alice", "alice's secret")
+
+	if got := m.History("alice", "alice", 10, nil); len(got) != 1 {
+		t.Fatalf("History(alice, alice) = %d entries, want 1 (alice reading her own DMs)", len(got))
+	}
+	if got := m.History("mallory", "alice", 10, nil); len(got) != 0 {
+		t.Fatalf("History(mallory, alice) = %+v, want no entries - mallory isn't alice", got)
+	}
+}
+
+// TestHistoryGroupRequiresMembership checks that History only serves a
+// group's buffer to requesters whose groupMemberships include it.
+func TestHistoryGroupRequiresMembership(t *testing.T) {
+	m := NewManager(&fakeStore{lastSeen: make(map[string]time.Time)}, 10)
+	m.RecordGroup("alice", "team", "team chatter")
+
+	if got := m.History("alice", "team", 10, []string{"team"}); len(got) != 1 {
+		t.Fatalf("History(alice, team) = %d entries, want 1 (alice is a member)", len(got))
+	}
+	if got := m.History("mallory", "team", 10, nil); len(got) != 0 {
+		t.Fatalf("History(mallory, team) = %+v, want no entries - mallory isn't a member", got)
+	}
+}
+
+// TestEntriesForCannotReadAnotherUsersDMs checks that entriesFor (and
+// therefore LatestFor/BeforeFor/BetweenFor) only returns the two-party DM
+// conversation requester is actually part of.
+func TestEntriesForCannotReadAnotherUsersDMs(t *testing.T) {
+	m := NewManager(&fakeStore{lastSeen: make(map[string]time.Time)}, 10)
+	m.RecordDM("alice", "bob", "hey bob")
+
+	if got := m.LatestFor("bob", "alice", 10, nil); len(got) != 1 {
+		t.Fatalf("LatestFor(bob, alice) = %d entries, want 1 (bob is a party to this DM)", len(got))
+	}
+	if got := m.LatestFor("mallory", "alice", 10, nil); len(got) != 0 {
+		t.Fatalf("LatestFor(mallory, alice) = %+v, want no entries - mallory is party to neither side", got)
+	}
+}
+
+// TestEntriesForGroupRequiresMembership checks that entriesFor (and
+// therefore CHATHISTORY) never returns a group's scrollback to a
+// requester whose groupMemberships don't include it - the request this
+// fixes explicitly: "DM history should be visible to both endpoints
+// only", which the group branch didn't previously honor either.
+func TestEntriesForGroupRequiresMembership(t *testing.T) {
+	m := NewManager(&fakeStore{lastSeen: make(map[string]time.Time)}, 10)
+	m.RecordGroup("alice", "team", "team chatter")
+
+	if got := m.LatestFor("alice", "team", 10, []string{"team"}); len(got) != 1 {
+		t.Fatalf("LatestFor(alice, team) = %d entries, want 1 (alice is a member)", len(got))
+	}
+	if got := m.LatestFor("mallory", "team", 10, nil); len(got) != 0 {
+		t.Fatalf("LatestFor(mallory, team) = %+v, want no entries - mallory isn't a member", got)
+	}
+}
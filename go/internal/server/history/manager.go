@@ -0,0 +1,278 @@
+package history
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Manager owns the full set of history buffers for a running server: one
+// global buffer, one per user (DMs addressed to them) and one per group,
+// plus the persisted lastSeen timestamps used to decide what a reconnecting
+// user missed.
+type Manager struct {
+	store Store
+
+	global *Buffer
+
+	mu        sync.Mutex
+	userBufs  map[string]*Buffer // username -> DMs addressed to that user
+	groupBufs map[string]*Buffer // group name -> messages sent to that group
+	lastSeen  map[string]time.Time
+	capacity  int
+
+	lastID uint64 // monotonically increasing Entry ID, see nextID
+}
+
+// NewManager creates a Manager backed by store, loading any previously
+// persisted lastSeen timestamps. A failure to load is treated as "no prior
+// state" rather than fatal, since history is a convenience, not a
+// correctness requirement.
+func NewManager(store Store, capacity int) *Manager {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	m := &Manager{
+		store:     store,
+		global:    NewBuffer(capacity),
+		userBufs:  make(map[string]*Buffer),
+		groupBufs: make(map[string]*Buffer),
+		lastSeen:  make(map[string]time.Time),
+		capacity:  capacity,
+	}
+	if loaded, err := store.LoadLastSeen(); err == nil {
+		m.lastSeen = loaded
+	}
+	return m
+}
+
+func (m *Manager) bufferFor(set map[string]*Buffer, key string) *Buffer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := set[key]
+	if !ok {
+		b = NewBuffer(m.capacity)
+		set[key] = b
+	}
+	return b
+}
+
+// nextID returns the next value in the process-wide monotonically
+// increasing Entry ID sequence, shared across every buffer so a msgid is
+// never reused regardless of which target it was recorded against (that's
+// what lets CHATHISTORY BEFORE/BETWEEN compare IDs meaningfully).
+func (m *Manager) nextID() uint64 {
+	return atomic.AddUint64(&m.lastID, 1)
+}
+
+// RecordGlobal appends a global-channel message to the global buffer.
+func (m *Manager) RecordGlobal(from, text string) {
+	m.global.Add(Entry{ID: m.nextID(), Timestamp: time.Now(), From: from, Kind: KindGlobal, Text: text})
+}
+
+// RecordDM appends a direct message to the recipient's personal buffer so
+// it can be replayed to them on their next login, even if they were
+// offline when it was sent.
+func (m *Manager) RecordDM(from, to, text string) {
+	e := Entry{ID: m.nextID(), Timestamp: time.Now(), From: from, Target: to, Kind: KindDM, Text: text}
+	m.bufferFor(m.userBufs, to).Add(e)
+}
+
+// RecordGroup appends a group message to that group's buffer.
+func (m *Manager) RecordGroup(from, group, text string) {
+	e := Entry{ID: m.nextID(), Timestamp: time.Now(), From: from, Target: group, Kind: KindGroup, Text: text}
+	m.bufferFor(m.groupBufs, group).Add(e)
+}
+
+// LastSeen returns the last recorded lastSeen timestamp for username, or
+// the zero time if the user has never been seen before (in which case
+// callers should not attempt a replay - everything is "new").
+func (m *Manager) LastSeen(username string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastSeen[username]
+}
+
+// Touch records username's lastSeen as now and persists the change. It is
+// called on graceful disconnect and periodically during long sessions.
+func (m *Manager) Touch(username string) {
+	m.mu.Lock()
+	m.lastSeen[username] = time.Now()
+	snapshot := make(map[string]time.Time, len(m.lastSeen))
+	for k, v := range m.lastSeen {
+		snapshot[k] = v
+	}
+	m.mu.Unlock()
+
+	// Best-effort; a failed save just means the next replay window is
+	// slightly wider than it should be, not a correctness problem.
+	_ = m.store.SaveLastSeen(snapshot)
+}
+
+// ReplayFor returns everything username missed since their lastSeen: global
+// traffic, DMs addressed to them, and messages in the groups they belong
+// to, merged and sorted chronologically.
+func (m *Manager) ReplayFor(username string, groupMemberships []string) []Entry {
+	since := m.LastSeen(username)
+
+	var merged []Entry
+	merged = append(merged, m.global.Between(since, time.Time{})...)
+
+	m.mu.Lock()
+	userBuf, hasUserBuf := m.userBufs[username]
+	groupBufsSnapshot := make([]*Buffer, 0, len(groupMemberships))
+	for _, g := range groupMemberships {
+		if buf, ok := m.groupBufs[g]; ok {
+			groupBufsSnapshot = append(groupBufsSnapshot, buf)
+		}
+	}
+	m.mu.Unlock()
+
+	if hasUserBuf {
+		merged = append(merged, userBuf.Between(since, time.Time{})...)
+	}
+	for _, buf := range groupBufsSnapshot {
+		merged = append(merged, buf.Between(since, time.Time{})...)
+	}
+
+	sortEntriesByTime(merged)
+	return merged
+}
+
+// History answers a HISTORY <target> <count> request: target is either ""
+// (global), one of requester's groupMemberships, or requester itself (for
+// requester's own DM buffer) - any other target, including some other
+// user's name, yields no entries, since userBufs[x] holds every DM
+// addressed to x from anyone and isn't requester's to read.
+func (m *Manager) History(requester, target string, count int, groupMemberships []string) []Entry {
+	var buf *Buffer
+	switch {
+	case target == "":
+		buf = m.global
+	case containsString(groupMemberships, target):
+		m.mu.Lock()
+		buf = m.groupBufs[target]
+		m.mu.Unlock()
+	case target == requester:
+		m.mu.Lock()
+		buf = m.userBufs[requester]
+		m.mu.Unlock()
+	}
+	if buf == nil {
+		return nil
+	}
+	return buf.Tail(count)
+}
+
+// dmEntries returns the full conversation between a and b: every entry
+// either of them sent the other, chronologically. RecordDM only files a
+// message into the recipient's own buffer, so this merges both sides'
+// buffers and filters to just the two of them - a requester can only ever
+// pull this for themselves as one of the two parties (see entriesFor), so
+// it's not a way to read a conversation you're not part of.
+func (m *Manager) dmEntries(a, b string) []Entry {
+	m.mu.Lock()
+	bufA, hasA := m.userBufs[a]
+	bufB, hasB := m.userBufs[b]
+	m.mu.Unlock()
+
+	var merged []Entry
+	if hasA {
+		for _, e := range bufA.All() {
+			if e.From == b {
+				merged = append(merged, e)
+			}
+		}
+	}
+	if hasB {
+		for _, e := range bufB.All() {
+			if e.From == a {
+				merged = append(merged, e)
+			}
+		}
+	}
+	sortEntriesByTime(merged)
+	return merged
+}
+
+// entriesFor resolves a CHATHISTORY target the same way History does -
+// "" is the global buffer, one of requester's groupMemberships is that
+// group's buffer - except a target that's neither resolves to the
+// two-party DM conversation between requester and target rather than
+// requester's own "everything addressed to me" buffer, since CHATHISTORY's
+// target names the other party in a specific conversation. A target that
+// names a real group requester isn't a member of yields no entries rather
+// than falling through to dmEntries, since DM history should be visible to
+// both endpoints only.
+func (m *Manager) entriesFor(requester, target string, groupMemberships []string) []Entry {
+	if target == "" {
+		return m.global.All()
+	}
+
+	m.mu.Lock()
+	buf, isGroup := m.groupBufs[target]
+	m.mu.Unlock()
+	if isGroup {
+		if !containsString(groupMemberships, target) {
+			return nil
+		}
+		return buf.All()
+	}
+	return m.dmEntries(requester, target)
+}
+
+// LatestFor answers CHATHISTORY LATEST: the most recent n entries for
+// target, from requester's point of view (see entriesFor).
+func (m *Manager) LatestFor(requester, target string, n int, groupMemberships []string) []Entry {
+	return tailEntries(m.entriesFor(requester, target, groupMemberships), n)
+}
+
+// BeforeFor answers CHATHISTORY BEFORE: up to the n entries immediately
+// preceding before's msgid.
+func (m *Manager) BeforeFor(requester, target string, before uint64, n int, groupMemberships []string) []Entry {
+	all := m.entriesFor(requester, target, groupMemberships)
+	filtered := make([]Entry, 0, len(all))
+	for _, e := range all {
+		if e.ID < before {
+			filtered = append(filtered, e)
+		}
+	}
+	return tailEntries(filtered, n)
+}
+
+// BetweenFor answers CHATHISTORY BETWEEN: up to the n oldest entries with
+// fromID < ID <= toID.
+func (m *Manager) BetweenFor(requester, target string, fromID, toID uint64, n int, groupMemberships []string) []Entry {
+	all := m.entriesFor(requester, target, groupMemberships)
+	filtered := make([]Entry, 0, len(all))
+	for _, e := range all {
+		if e.ID > fromID && e.ID <= toID {
+			filtered = append(filtered, e)
+		}
+	}
+	if n > 0 && n < len(filtered) {
+		filtered = filtered[:n]
+	}
+	return filtered
+}
+
+// containsString reports whether s appears in list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortEntriesByTime(entries []Entry) {
+	// Simple insertion sort: replay batches are small (bounded by buffer
+	// capacity) so this is plenty fast and avoids pulling in "sort" for a
+	// handful of comparisons per login.
+	for i := 1; i < len(entries); i++ {
+		for j := i; j > 0 && entries[j].Timestamp.Before(entries[j-1].Timestamp); j-- {
+			entries[j], entries[j-1] = entries[j-1], entries[j]
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store persists per-user lastSeen timestamps so history replay survives a
+// server restart. Implementations must be safe for concurrent use.
+type Store interface {
+	// LoadLastSeen returns the last known lastSeen timestamp per username.
+	// A missing file is not an error; it just yields an empty map.
+	LoadLastSeen() (map[string]time.Time, error)
+	// SaveLastSeen persists the full lastSeen map, replacing whatever was
+	// there before.
+	SaveLastSeen(lastSeen map[string]time.Time) error
+}
+
+// JSONStore is a Store backed by a single JSON file on disk. It is the
+// default; a SQLite-backed Store can implement the same interface later
+// without touching callers.
+type JSONStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewJSONStore returns a JSONStore that reads/writes lastSeen data at path.
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{path: path}
+}
+
+func (s *JSONStore) LoadLastSeen() (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]time.Time), nil
+		}
+		return nil, fmt.Errorf("history: reading %s: %w", s.path, err)
+	}
+	if len(data) == 0 {
+		return make(map[string]time.Time), nil
+	}
+
+	var raw map[string]time.Time
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("history: parsing %s: %w", s.path, err)
+	}
+	return raw, nil
+}
+
+func (s *JSONStore) SaveLastSeen(lastSeen map[string]time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(lastSeen, "", "  ")
+	if err != nil {
+		return fmt.Errorf("history: encoding lastSeen: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("history: writing %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("history: replacing %s: %w", s.path, err)
+	}
+	return nil
+}
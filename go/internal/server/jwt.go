@@ -0,0 +1,146 @@
+// tincan/internal/server/jwt.go
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"tincan/internal/server/jwtauth"
+)
+
+const (
+	JWT_SECRET_FILE = "config/jwt_secret"
+	JWT_TOKEN_TTL   = 24 * time.Hour
+)
+
+var jwtSecret []byte
+
+// loadJWTSecret reads the shared HS256 signing key from config/jwt_secret.
+// A missing file leaves jwtSecret empty, which fails /login closed and
+// makes every AUTH <token> attempt get rejected by resolveBearerToken -
+// the same fail-closed behavior loadUsers has for a missing users.json.
+func loadJWTSecret() {
+	data, err := os.ReadFile(JWT_SECRET_FILE)
+	if err != nil {
+		log.Printf("Warning: Could not open %s: %v. Token auth and /login are disabled.", JWT_SECRET_FILE, err)
+		return
+	}
+	jwtSecret = bytes.TrimSpace(data)
+	log.Printf("Loaded JWT signing secret from %s.", JWT_SECRET_FILE)
+}
+
+// resolveBearerToken verifies token and reports the identity it grants.
+// The groups claim is informational only - group membership for history
+// replay and routing is still resolved from config/groups.txt via
+// groupsForMember, so a stale or forged groups claim can't grant access
+// to a group the server doesn't actually have the user in. Operator
+// status likewise comes from the current config/users.json record for
+// the claimed username, not from the token.
+func resolveBearerToken(token string) (username string, groups []string, isOperator bool, ok bool) {
+	if len(jwtSecret) == 0 {
+		return "", nil, false, false
+	}
+	claims, err := jwtauth.Verify(jwtSecret, token)
+	if err != nil {
+		return "", nil, false, false
+	}
+	if rec, found := lookupUser(claims.Username); found {
+		isOperator = rec.Operator
+	}
+	return claims.Username, claims.Groups, isOperator, true
+}
+
+// authenticateBearer verifies a client-presented JWT and, on success, marks
+// client active under the claimed username. Shared by the WebSocket
+// pre-auth path (Authorization header / access_token query param) and the
+// TCP "AUTH <token>" first-line path in runSession.
+func authenticateBearer(client *ClientInfo, token, remoteAddr string) (string, bool) {
+	username, _, isOperator, ok := resolveBearerToken(token)
+	if !ok {
+		sendToClient(client, "AUTH_FAILED\nInvalid or expired token.")
+		log.Printf("Bearer token rejected for %s.", remoteAddr)
+		return "", false
+	}
+	if _, exists := findActiveClientByUsername(username); exists {
+		sendToClient(client, "BAD_USERNAME\nUsername already in use.")
+		log.Printf("Client %s presented a token for '%s' which is already active.", remoteAddr, username)
+		return "", false
+	}
+
+	clientsMutex.Lock()
+	client.username = username
+	client.active = true
+	client.isOperator = isOperator
+	clientsMutex.Unlock()
+
+	log.Printf("Username '%s' (bearer token) authenticated for %s.", username, remoteAddr)
+	return username, true
+}
+
+// bearerTokenFromRequest extracts a client-presented token from a
+// WebSocket upgrade request, accepting either an "Authorization: Bearer
+// <t>" header or a "?access_token=" query parameter, since browser
+// WebSocket clients can't set arbitrary headers on the handshake.
+func bearerTokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type loginResponse struct {
+	Token string `json:"token"`
+}
+
+// handleLogin exchanges a username/password pair for a signed JWT,
+// checked against the same bcrypt hash SASL PLAIN uses, so a password is
+// only ever verified in one place. Clients that would rather log in with
+// a long-lived token than repeat a SASL exchange on every reconnect can
+// call this once and then present the token via AUTH <token> (TCP) or
+// access_token (WebSocket).
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(jwtSecret) == 0 {
+		http.Error(w, "token auth is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "malformed request body", http.StatusBadRequest)
+		return
+	}
+
+	rec, ok := lookupUser(req.Username)
+	if !ok || bcrypt.CompareHashAndPassword(rec.BcryptHash, []byte(req.Password)) != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := jwtauth.Issue(jwtSecret, req.Username, groupsForMember(req.Username), JWT_TOKEN_TTL)
+	if err != nil {
+		log.Printf("Login: could not issue token for %s: %v", req.Username, err)
+		http.Error(w, "could not issue token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(loginResponse{Token: token}); err != nil {
+		log.Printf("Login: could not encode response for %s: %v", req.Username, err)
+	}
+}
@@ -0,0 +1,52 @@
+// tincan/internal/server/jwtauth/jwtauth.go
+package jwtauth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the custom fields tincan signs into every token, alongside the
+// standard registered claims (exp, iat, ...). A token's signature is the
+// only thing that makes Username and Groups trustworthy - callers must
+// always go through Verify before trusting either field.
+type Claims struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+	jwt.RegisteredClaims
+}
+
+// Issue signs a new HS256 token for username, embedding groups as a claim
+// so a verifier doesn't have to re-resolve group membership on its own,
+// expiring after ttl.
+func Issue(secret []byte, username string, groups []string, ttl time.Duration) (string, error) {
+	claims := Claims{
+		Username: username,
+		Groups:   groups,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// Verify checks tokenString's signature and expiry against secret and
+// returns the claims it carries. Every failure mode (bad signature,
+// expired, malformed) collapses to the same generic error, the same way
+// authPlain and authScram don't distinguish why a login failed.
+func Verify(secret []byte, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+	return claims, nil
+}
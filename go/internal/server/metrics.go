@@ -0,0 +1,78 @@
+// tincan/internal/server/metrics.go
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	metricsConnectionsTotal int64
+	metricsMessagesTotal    int64
+	metricsBroadcastNanos   int64 // accumulated broadcastMessage duration
+	metricsBroadcastCount   int64
+)
+
+// recordConnection counts one accepted TCP or WebSocket connection, for
+// the tincan_connections_total metric.
+func recordConnection() {
+	atomic.AddInt64(&metricsConnectionsTotal, 1)
+}
+
+// recordMessageReceived counts one chat message that made it past the
+// fakelag check and was dispatched, for the tincan_messages_total metric.
+func recordMessageReceived() {
+	atomic.AddInt64(&metricsMessagesTotal, 1)
+}
+
+// recordBroadcast accumulates how long a single broadcastMessage call
+// took, for the tincan_broadcast_seconds summary.
+func recordBroadcast(d time.Duration) {
+	atomic.AddInt64(&metricsBroadcastNanos, int64(d))
+	atomic.AddInt64(&metricsBroadcastCount, 1)
+}
+
+// handleMetrics exposes counters in the Prometheus text exposition format.
+// Hand-rolled rather than pulling in client_golang, the same call the
+// config parsers in this package make to avoid a dependency for a
+// handful of numbers.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	clientsMutex.RLock()
+	active := 0
+	for _, c := range clients {
+		if c.active {
+			active++
+		}
+	}
+	clientsMutex.RUnlock()
+
+	broadcastSeconds := float64(atomic.LoadInt64(&metricsBroadcastNanos)) / float64(time.Second)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP tincan_active_clients Currently connected, authenticated clients.\n")
+	fmt.Fprintf(w, "# TYPE tincan_active_clients gauge\n")
+	fmt.Fprintf(w, "tincan_active_clients %d\n", active)
+
+	fmt.Fprintf(w, "# HELP tincan_connections_total Accepted TCP and WebSocket connections since start.\n")
+	fmt.Fprintf(w, "# TYPE tincan_connections_total counter\n")
+	fmt.Fprintf(w, "tincan_connections_total %d\n", atomic.LoadInt64(&metricsConnectionsTotal))
+
+	fmt.Fprintf(w, "# HELP tincan_messages_total Chat messages dispatched since start.\n")
+	fmt.Fprintf(w, "# TYPE tincan_messages_total counter\n")
+	fmt.Fprintf(w, "tincan_messages_total %d\n", atomic.LoadInt64(&metricsMessagesTotal))
+
+	fmt.Fprintf(w, "# HELP tincan_broadcast_seconds Time spent in broadcastMessage.\n")
+	fmt.Fprintf(w, "# TYPE tincan_broadcast_seconds summary\n")
+	fmt.Fprintf(w, "tincan_broadcast_seconds_sum %f\n", broadcastSeconds)
+	fmt.Fprintf(w, "tincan_broadcast_seconds_count %d\n", atomic.LoadInt64(&metricsBroadcastCount))
+}
+
+// handleHealthz reports process liveness for load balancers and
+// orchestrators. It deliberately doesn't check downstream state like
+// config freshness - just that the HTTP server is able to respond.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
@@ -0,0 +1,267 @@
+// tincan/internal/server/ratelimit.go
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LimitsConfig holds the tunables for per-connection flood control and
+// per-IP connection throttling, loaded from config/limits.yaml.
+type LimitsConfig struct {
+	FakelagWindow   time.Duration
+	FakelagBurst    int
+	FakelagCooldown time.Duration
+	ConnPerIPPerMin int
+}
+
+var defaultLimitsConfig = LimitsConfig{
+	FakelagWindow:   2 * time.Second,
+	FakelagBurst:    5,
+	FakelagCooldown: 10 * time.Second,
+	ConnPerIPPerMin: 20,
+}
+
+const LIMITS_CONFIG_FILE = "config/limits.yaml"
+
+// loadLimitsConfig reads config/limits.yaml if present, falling back to
+// defaultLimitsConfig for anything missing or malformed. The file is a
+// minimal "key: value" subset of YAML - just enough for flat scalars -
+// matching the hand-rolled parser already used for groups.txt,
+// so this doesn't need to pull in a YAML library for four numbers.
+func loadLimitsConfig() LimitsConfig {
+	cfg := defaultLimitsConfig
+
+	file, err := os.Open(LIMITS_CONFIG_FILE)
+	if err != nil {
+		log.Printf(
+			"Warning: Could not open %s: %v. Using default rate limits.",
+			LIMITS_CONFIG_FILE,
+			err,
+		)
+		return cfg
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			log.Printf("Skipping malformed limits line: %s", line)
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		switch key {
+		case "fakelag_window_seconds":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.FakelagWindow = time.Duration(n) * time.Second
+			}
+		case "fakelag_burst":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.FakelagBurst = n
+			}
+		case "fakelag_cooldown_seconds":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.FakelagCooldown = time.Duration(n) * time.Second
+			}
+		case "conn_per_ip_per_minute":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.ConnPerIPPerMin = n
+			}
+		default:
+			log.Printf("Warning: Unknown key '%s' in %s, ignoring.", key, LIMITS_CONFIG_FILE)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading %s: %v", LIMITS_CONFIG_FILE, err)
+	}
+	log.Printf("Loaded rate limits from %s: %+v", LIMITS_CONFIG_FILE, cfg)
+	return cfg
+}
+
+// Fakelag is a per-connection token-bucket flood limiter, modeled on the
+// "fakelag" pattern in oragono's irc/client.go: a burst of messages is
+// allowed immediately, then refills at a steady rate defined by window.
+// Running dry doesn't just block the next message - it starts a cooldown,
+// so a client can't flood at exactly the refill rate forever.
+type Fakelag struct {
+	mu             sync.Mutex
+	burst          int
+	window         time.Duration
+	cooldown       time.Duration
+	tokens         float64
+	lastRefill     time.Time
+	penalizedUntil time.Time
+}
+
+// NewFakelag creates a Fakelag with a full bucket.
+func NewFakelag(window time.Duration, burst int, cooldown time.Duration) *Fakelag {
+	return &Fakelag{
+		burst:      burst,
+		window:     window,
+		cooldown:   cooldown,
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+	}
+}
+
+// Touch consumes one token if available and reports whether the caller may
+// proceed. Call it before dispatching each parsed command.
+func (f *Fakelag) Touch() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(f.penalizedUntil) {
+		return false
+	}
+
+	if f.window > 0 {
+		elapsed := now.Sub(f.lastRefill)
+		refillRate := float64(f.burst) / f.window.Seconds()
+		f.tokens += elapsed.Seconds() * refillRate
+		if f.tokens > float64(f.burst) {
+			f.tokens = float64(f.burst)
+		}
+	}
+	f.lastRefill = now
+
+	if f.tokens < 1 {
+		f.penalizedUntil = now.Add(f.cooldown)
+		return false
+	}
+	f.tokens--
+	return true
+}
+
+// Snapshot returns the current token count and whether the bucket is
+// presently serving a cooldown penalty, for the /debug/limits endpoint.
+func (f *Fakelag) Snapshot() (tokens float64, penalized bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tokens, time.Now().Before(f.penalizedUntil)
+}
+
+// connThrottle rate-limits new connections per remote IP using a sliding
+// window, so a single peer can't exhaust the process by opening thousands
+// of half-open sockets before ever reaching the username handshake.
+type connThrottle struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+}
+
+func newConnThrottle(limit int, window time.Duration) *connThrottle {
+	return &connThrottle{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// Allow records a new connection attempt from ip and reports whether it
+// falls within the configured per-IP limit.
+func (c *connThrottle) Allow(ip string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-c.window)
+
+	existing := c.hits[ip]
+	recent := make([]time.Time, 0, len(existing))
+	for _, t := range existing {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= c.limit {
+		c.hits[ip] = recent
+		return false
+	}
+	c.hits[ip] = append(recent, now)
+	return true
+}
+
+// Snapshot returns a copy of the current per-IP hit counts (within the
+// window), for the /debug/limits endpoint.
+func (c *connThrottle) Snapshot() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-c.window)
+	out := make(map[string]int, len(c.hits))
+	for ip, hits := range c.hits {
+		count := 0
+		for _, t := range hits {
+			if t.After(cutoff) {
+				count++
+			}
+		}
+		if count > 0 {
+			out[ip] = count
+		}
+	}
+	return out
+}
+
+// ipFromRemoteAddr strips the port off a "host:port" remote address string,
+// falling back to the raw value if it doesn't parse (e.g. already bare).
+func ipFromRemoteAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// handleDebugLimits serves current rate-limit state as JSON so operators
+// can see what's being throttled without grepping logs.
+func handleDebugLimits(w http.ResponseWriter, r *http.Request) {
+	type fakelagState struct {
+		Username  string  `json:"username"`
+		Tokens    float64 `json:"tokens"`
+		Penalized bool    `json:"penalized"`
+	}
+
+	clientsMutex.RLock()
+	fakelagStates := make([]fakelagState, 0, len(clients))
+	for _, c := range clients {
+		if c.fakelag == nil {
+			continue
+		}
+		tokens, penalized := c.fakelag.Snapshot()
+		fakelagStates = append(fakelagStates, fakelagState{
+			Username:  c.username,
+			Tokens:    tokens,
+			Penalized: penalized,
+		})
+	}
+	clientsMutex.RUnlock()
+
+	resp := struct {
+		Limits          LimitsConfig   `json:"limits"`
+		ConnectionsByIP map[string]int `json:"connections_by_ip"`
+		Clients         []fakelagState `json:"clients"`
+	}{
+		Limits:          limitsConfig,
+		ConnectionsByIP: globalConnThrottle.Snapshot(),
+		Clients:         fakelagStates,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error encoding /debug/limits response: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// tincan/internal/server/reload.go
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+)
+
+// watchForReloadSignal wires SIGHUP to reloadConfig, so operators can push
+// new config/users.json and config/groups.txt without a restart. Runs for
+// the lifetime of the process.
+func watchForReloadSignal() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			log.Println("Received SIGHUP, reloading users.json and groups.txt.")
+			reloadConfig()
+		}
+	}()
+}
+
+// reloadConfig re-reads config/users.json and config/groups.txt into fresh
+// maps and atomically swaps them in, the same path used by the SIGHUP
+// handler and the operator-only RELOAD wire command. Affected connected
+// clients are notified right after the new config is swapped in.
+func reloadConfig() {
+	reloadUsers()
+	reloadGroups()
+}
+
+// reloadUsers snapshots the current user set, reloads config/users.json,
+// diffs the two, and disconnects any client whose username was revoked.
+func reloadUsers() {
+	before := snapshotUsernames()
+	loadUsers()
+	after := snapshotUsernames()
+
+	added, removed := diffStringSets(before, after)
+	log.Printf("User reload: %d added %v, %d removed %v.", len(added), added, len(removed), removed)
+
+	for _, username := range removed {
+		if client, ok := findActiveClientByUsername(username); ok {
+			sendToClient(client, "NOT_ALLOWED\nRevoked by administrator.")
+			client.transport.Close()
+		}
+	}
+}
+
+// reloadGroups snapshots the current group membership, reloads
+// config/groups.txt, diffs the two, and broadcasts a membership-changed
+// notice to every member (old or new) of a group whose roster changed.
+func reloadGroups() {
+	before := snapshotGroupMembers()
+	loadGroups()
+	after := snapshotGroupMembers()
+
+	var addedGroups, removedGroups, changedGroups []string
+	for name, newMembers := range after {
+		oldMembers, existed := before[name]
+		if !existed {
+			addedGroups = append(addedGroups, name)
+			continue
+		}
+		if !sameMembers(oldMembers, newMembers) {
+			changedGroups = append(changedGroups, name)
+			notifyGroupMembershipChanged(name, oldMembers, newMembers)
+		}
+	}
+	for name := range before {
+		if _, stillExists := after[name]; !stillExists {
+			removedGroups = append(removedGroups, name)
+		}
+	}
+	sort.Strings(addedGroups)
+	sort.Strings(removedGroups)
+	sort.Strings(changedGroups)
+
+	log.Printf(
+		"Group reload: %d added %v, %d removed %v, %d changed %v.",
+		len(addedGroups), addedGroups,
+		len(removedGroups), removedGroups,
+		len(changedGroups), changedGroups,
+	)
+}
+
+// notifyGroupMembershipChanged tells every member of a changed group's old
+// and new rosters that something changed, so someone who was just removed
+// finds out as surely as someone who was just added.
+func notifyGroupMembershipChanged(groupName string, oldMembers, newMembers []string) {
+	notified := make(map[string]bool)
+	msg := fmt.Sprintf("System: group #%s membership updated.", groupName)
+	for _, username := range append(append([]string{}, oldMembers...), newMembers...) {
+		if notified[username] {
+			continue
+		}
+		notified[username] = true
+		if client, ok := findActiveClientByUsername(username); ok {
+			sendToClient(client, msg)
+		}
+	}
+}
+
+// snapshotUsernames returns the current set of known usernames, for diffing
+// across a reload.
+func snapshotUsernames() map[string]bool {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	out := make(map[string]bool, len(users))
+	for username := range users {
+		out[username] = true
+	}
+	return out
+}
+
+// snapshotGroupMembers returns a deep copy of the current group roster, for
+// diffing across a reload.
+func snapshotGroupMembers() map[string][]string {
+	groupsMutex.RLock()
+	defer groupsMutex.RUnlock()
+
+	out := make(map[string][]string, len(groups))
+	for name, g := range groups {
+		members := make([]string, len(g.members))
+		copy(members, g.members)
+		out[name] = members
+	}
+	return out
+}
+
+// diffStringSets returns the keys present in after but not before (added)
+// and those present in before but not after (removed), both sorted.
+func diffStringSets(before, after map[string]bool) (added, removed []string) {
+	for k := range after {
+		if !before[k] {
+			added = append(added, k)
+		}
+	}
+	for k := range before {
+		if !after[k] {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// sameMembers reports whether two group rosters have the same members,
+// order ignored.
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	aSorted := append([]string{}, a...)
+	bSorted := append([]string{}, b...)
+	sort.Strings(aSorted)
+	sort.Strings(bSorted)
+	for i := range aSorted {
+		if aSorted[i] != bSorted[i] {
+			return false
+		}
+	}
+	return true
+}
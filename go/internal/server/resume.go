@@ -0,0 +1,117 @@
+// tincan/internal/server/resume.go
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	mrand "math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resumeTokenTTL bounds how long an issued resume token stays valid,
+// mirroring IRCv3 RESUME's short-lived token model: long enough to
+// survive a flaky network dropping and re-establishing a connection, not
+// long enough to be useful to an attacker who stole a logged line.
+const resumeTokenTTL = 5 * time.Minute
+
+// resumeGrant is what a resume token resolves to until it's consumed or
+// expires.
+type resumeGrant struct {
+	username  string
+	expiresAt time.Time
+}
+
+var (
+	resumeMutex  sync.Mutex
+	resumeTokens = make(map[string]resumeGrant)
+)
+
+// issueResumeToken generates a fresh single-use token for username and
+// registers it with a resumeTokenTTL expiry. Called after every
+// successful login and after every successful resume (rotation), so a
+// captured token is only ever good for one more reconnect.
+func issueResumeToken(username string) string {
+	token := randomToken()
+
+	resumeMutex.Lock()
+	resumeTokens[token] = resumeGrant{username: username, expiresAt: time.Now().Add(resumeTokenTTL)}
+	resumeMutex.Unlock()
+
+	return token
+}
+
+// consumeResumeToken looks up token, returning the username it grants
+// and true if it existed and hadn't expired. Either way the token is
+// removed - a resume token is single-use, successful or not, to prevent
+// a stolen or replayed token from being tried again.
+func consumeResumeToken(token string) (string, bool) {
+	resumeMutex.Lock()
+	grant, found := resumeTokens[token]
+	delete(resumeTokens, token)
+	resumeMutex.Unlock()
+
+	if !found || time.Now().After(grant.expiresAt) {
+		return "", false
+	}
+	return grant.username, true
+}
+
+// resumeSession handles a "RESUME <token> <lastSeenMsgID>" line sent in
+// place of a username (see runSession). lastSeenMsgID is accepted for
+// protocol completeness but isn't used to bound replay itself -
+// historyMgr.ReplayFor's existing lastSeen-timestamp buffers already
+// cover exactly the same "what did I miss" gap a reconnect needs, so
+// there's no second, message-ID-indexed replay path to maintain here.
+func resumeSession(client *ClientInfo, line, remoteAddr string) (string, bool) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		sendToClient(client, "RESUME_FAILED\nMalformed RESUME command.")
+		return "", false
+	}
+	token := parts[1]
+
+	username, ok := consumeResumeToken(token)
+	if !ok {
+		sendToClient(client, "RESUME_FAILED\nResume token invalid or expired.")
+		log.Printf("Resume token rejected for %s.", remoteAddr)
+		return "", false
+	}
+	if _, exists := findActiveClientByUsername(username); exists {
+		sendToClient(client, "RESUME_FAILED\nSession already active.")
+		log.Printf("Client %s tried to resume '%s' which is already active.", remoteAddr, username)
+		return "", false
+	}
+	rec, ok := lookupUser(username)
+	if !ok {
+		sendToClient(client, "RESUME_FAILED\nUser no longer recognized.")
+		log.Printf("Resume for '%s' rejected: user no longer exists.", username)
+		return "", false
+	}
+
+	clientsMutex.Lock()
+	client.username = username
+	client.active = true
+	client.isOperator = rec.Operator
+	clientsMutex.Unlock()
+
+	log.Printf("Username '%s' resumed session for %s.", username, remoteAddr)
+	return username, true
+}
+
+// randomToken returns a 256-bit random value hex-encoded, opaque to the
+// client beyond "present it back verbatim".
+func randomToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand failing is effectively unrecoverable for anything
+		// security-sensitive; log loudly and fall back to a value that's
+		// at least unpredictable to a casual observer rather than panic
+		// mid-session.
+		log.Printf("Error generating resume token: %v", err)
+		mrand.New(mrand.NewSource(time.Now().UnixNano())).Read(buf)
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,105 @@
+// tincan/internal/server/sendqueue.go
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sendQueueSize bounds how many outbound messages sendToClient will
+	// buffer for a client before it starts dropping new ones for that
+	// client, the same burst-absorbing role Fakelag's burst allowance
+	// plays on the inbound side.
+	sendQueueSize = 256
+	// sendQueueFullTimeout is how long a client's send queue may stay full
+	// (measured across sendToClient calls, which never wait) before the
+	// client is treated as a stalled consumer and evicted, so one slow
+	// peer can't stall broadcastMessage for everyone else.
+	sendQueueFullTimeout = 5 * time.Second
+)
+
+// sendQueueDrops counts clients evicted for a send queue that stayed full
+// past sendQueueFullTimeout, exposed via /debug/queues.
+var sendQueueDrops int64
+
+// clientWriter drains client.sendQueue and writes each message to its
+// transport, one at a time, so a broadcast never blocks on a slow
+// transport.Send call directly. It exits when done fires (the session
+// ending) or a write fails, in which case the read loop in runSession will
+// notice the broken transport and run the usual cleanup path.
+func clientWriter(client *ClientInfo, done <-chan struct{}) {
+	for {
+		select {
+		case msg := <-client.sendQueue:
+			if err := client.transport.Send(string(msg)); err != nil {
+				log.Printf(
+					"Error sending message to %s (%s): %v",
+					client.username,
+					client.transport.RemoteAddr(),
+					err,
+				)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// evictSlowClient closes a client whose send queue has stayed full for
+// longer than sendQueueFullTimeout and removes it from the registry, so a
+// stalled peer stops being offered to future broadcasts. The client's own
+// read loop notices the closed transport and runs its usual
+// cleanup/leave-message path.
+func evictSlowClient(client *ClientInfo) {
+	atomic.AddInt64(&sendQueueDrops, 1)
+	log.Printf(
+		"Evicting %s (%s): send queue full for over %s.",
+		client.username,
+		client.transport.RemoteAddr(),
+		sendQueueFullTimeout,
+	)
+	clientsMutex.Lock()
+	delete(clients, client.id)
+	clientsMutex.Unlock()
+	client.transport.Close()
+}
+
+// handleDebugQueues exposes each active client's outbound queue depth and
+// the running total of slow-consumer evictions, the same kind of
+// operational visibility /debug/limits gives into rate limiting.
+func handleDebugQueues(w http.ResponseWriter, r *http.Request) {
+	type queueState struct {
+		Username string `json:"username"`
+		Depth    int    `json:"depth"`
+		Capacity int    `json:"capacity"`
+	}
+
+	clientsMutex.RLock()
+	states := make([]queueState, 0, len(clients))
+	for _, c := range clients {
+		states = append(states, queueState{
+			Username: c.username,
+			Depth:    len(c.sendQueue),
+			Capacity: cap(c.sendQueue),
+		})
+	}
+	clientsMutex.RUnlock()
+
+	resp := struct {
+		Drops   int64        `json:"drops"`
+		Clients []queueState `json:"clients"`
+	}{
+		Drops:   atomic.LoadInt64(&sendQueueDrops),
+		Clients: states,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error encoding /debug/queues response: %v", err)
+	}
+}
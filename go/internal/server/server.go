@@ -4,18 +4,29 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"golang.org/x/crypto/acme/autocert"
 	"nhooyr.io/websocket"
+
+	"tincan/internal/ratelimit"
+	"tincan/internal/server/history"
 )
 
 const (
@@ -24,23 +35,218 @@ const (
 	USERNAME_MAX_LEN      = 50
 	GROUPNAME_MAX_LEN     = 50
 	CHAT_LOG_FILE         = "chat_log.txt"      // Relative to CWD
-	ALLOWED_USERS_FILE    = "config/users.txt"  // Adjusted path
 	GROUPS_FILE           = "config/groups.txt" // Adjusted path
-	MAX_HISTORY_LINES     = 20
+	LAST_SEEN_FILE        = "config/last_seen.json"
+	HISTORY_BUFFER_SIZE   = history.DefaultCapacity
+	DEFAULT_HISTORY_COUNT = 20
+	MAX_HISTORY_COUNT     = 200
+	LAST_SEEN_TOUCH_EVERY = time.Hour
 	MAX_ALLOWED_USERS     = 100 // Currently used for logging, not for hard limit in map
 	MAX_GROUPS            = 20  // Currently used for logging, not for hard limit in map
 	MAX_MEMBERS_PER_GROUP = 20  // Currently used for logging, not for hard limit in map
+	SHUTDOWN_GRACE_PERIOD = 10 * time.Second
 )
 
-// ClientInfo holds information about a connected client
+// Transport abstracts the wire-level connection a client arrived on, so the
+// session logic (login handshake, history replay, command dispatch) is
+// written once and works the same whether the peer is raw TCP or WebSocket.
+type Transport interface {
+	// Send writes a single line to the peer. A trailing "\n" is added if
+	// the caller didn't already include one.
+	Send(line string) error
+	// Recv blocks for the next line from the peer. The returned string
+	// always ends in "\n", matching the existing line protocol.
+	Recv() (string, error)
+	// RecvTimeout behaves like Recv but gives up after d, returning an
+	// error that satisfies net.Error.Timeout(). Used during capability
+	// negotiation, where the server cannot block forever on clients that
+	// don't know about CAP LS.
+	RecvTimeout(d time.Duration) (string, error)
+	RemoteAddr() string
+	Close() error
+	// Kind identifies the underlying wire protocol ("tcp" or "websocket"),
+	// for logging and diagnostics - session logic itself stays agnostic.
+	Kind() string
+	// PeerCertificates returns the client certificates presented during the
+	// TLS handshake, or nil if the connection isn't TLS or the client
+	// presented none. Used by the CERTFP SASL mechanism.
+	PeerCertificates() []*x509.Certificate
+}
+
+// tcpTransport implements Transport over a raw net.Conn.
+type tcpTransport struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func newTCPTransport(conn net.Conn) *tcpTransport {
+	return &tcpTransport{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+}
+
+func (t *tcpTransport) Send(line string) error {
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	if _, err := t.writer.WriteString(line); err != nil {
+		return err
+	}
+	return t.writer.Flush()
+}
+
+func (t *tcpTransport) Recv() (string, error) {
+	return t.reader.ReadString('\n')
+}
+
+func (t *tcpTransport) RecvTimeout(d time.Duration) (string, error) {
+	if err := t.conn.SetReadDeadline(time.Now().Add(d)); err != nil {
+		return "", err
+	}
+	defer t.conn.SetReadDeadline(time.Time{}) // clear the deadline for subsequent blocking reads
+
+	return t.reader.ReadString('\n')
+}
+
+func (t *tcpTransport) RemoteAddr() string {
+	return t.conn.RemoteAddr().String()
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *tcpTransport) Kind() string {
+	return "tcp"
+}
+
+// PeerCertificates returns the client certificate chain if conn is a TLS
+// connection that requested one, or nil for plain TCP.
+func (t *tcpTransport) PeerCertificates() []*x509.Certificate {
+	tlsConn, ok := t.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	return tlsConn.ConnectionState().PeerCertificates
+}
+
+// wsTransport implements Transport over a *websocket.Conn, translating the
+// text-message framing into the same line-oriented protocol TCP clients use.
+type wsTransport struct {
+	ctx      context.Context
+	conn     *websocket.Conn
+	remote   string
+	tlsState *tls.ConnectionState // nil unless the HTTP upgrade came in over TLS
+}
+
+func newWSTransport(ctx context.Context, conn *websocket.Conn, remote string, tlsState *tls.ConnectionState) *wsTransport {
+	return &wsTransport{ctx: ctx, conn: conn, remote: remote, tlsState: tlsState}
+}
+
+func (t *wsTransport) Send(line string) error {
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	return t.conn.Write(t.ctx, websocket.MessageText, []byte(line))
+}
+
+func (t *wsTransport) Recv() (string, error) {
+	msgType, p, err := t.conn.Read(t.ctx)
+	if err != nil {
+		return "", err
+	}
+	if msgType != websocket.MessageText {
+		return "", fmt.Errorf("tincan: ignoring non-text websocket frame from %s", t.remote)
+	}
+	line := string(p)
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	return line, nil
+}
+
+func (t *wsTransport) RecvTimeout(d time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(t.ctx, d)
+	defer cancel()
+
+	msgType, p, err := t.conn.Read(ctx)
+	if err != nil {
+		return "", err
+	}
+	if msgType != websocket.MessageText {
+		return "", fmt.Errorf("tincan: ignoring non-text websocket frame from %s", t.remote)
+	}
+	line := string(p)
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	return line, nil
+}
+
+func (t *wsTransport) RemoteAddr() string {
+	return t.remote
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close(websocket.StatusNormalClosure, "Connection closed by server")
+}
+
+func (t *wsTransport) Kind() string {
+	return "websocket"
+}
+
+// PeerCertificates returns the client certificate chain presented during
+// the TLS handshake that preceded the WebSocket upgrade, or nil if the
+// connection wasn't TLS or the client presented none.
+func (t *wsTransport) PeerCertificates() []*x509.Certificate {
+	if t.tlsState == nil {
+		return nil
+	}
+	return t.tlsState.PeerCertificates
+}
+
+// ClientInfo holds information about a connected client, independent of
+// which Transport it arrived on.
 type ClientInfo struct {
-	conn     net.Conn
-	username string
-	reader   *bufio.Reader
-	writer   *bufio.Writer
-	active   bool // True after successful username handshake
+	id         string
+	transport  Transport
+	username   string
+	active     bool               // True after successful username handshake
+	caps       map[string]bool    // capabilities negotiated via CAP LS/REQ/END
+	fakelag    *Fakelag           // per-connection flood limiter
+	verbLimit  *ratelimit.Limiter // per-verb token-bucket limiter; see the FAIL RATELIMIT handling in runSession
+	isOperator bool               // True if the authenticated user may run admin commands (e.g. RELOAD)
+	sendQueue  chan []byte        // outbound messages, drained by clientWriter; see sendqueue.go
+
+	// sendStalledSince is a UnixNano timestamp of when sendToClient first
+	// found this client's sendQueue full (0 means not currently stalled),
+	// accessed only via sync/atomic since it's written from whichever
+	// broadcasting goroutine happens to hit a full queue. See sendToClient's
+	// non-blocking select.
+	sendStalledSince int64
+}
+
+// hasCap reports whether client negotiated the named capability. A nil
+// client or nil caps map (pre-handshake) means no capabilities are enabled.
+func (c *ClientInfo) hasCap(name string) bool {
+	return c != nil && c.caps[name]
 }
 
+// supportedCaps are the capability tokens this server knows how to speak,
+// advertised verbatim in CAP LS. Inspired by the IRCv3 capability registry:
+// server-time (ISO-8601 timestamp tag on every line), message-tags (the
+// client may prefix its lines with an @tags section, which runSession
+// strips), history (acknowledged only; HISTORY and CHATHISTORY are served
+// whether or not it was negotiated), echo-message (opt in to receiving
+// your own global messages back) and ws-json (frame messages as JSON
+// instead of line text - WebSocket transport only).
+var supportedCaps = []string{"server-time", "message-tags", "history", "echo-message", "ws-json"}
+
+const capNegotiationTimeout = 3 * time.Second
+
 // GroupInfo holds information about a defined group
 type GroupInfo struct {
 	name    string
@@ -48,69 +254,230 @@ type GroupInfo struct {
 }
 
 var (
-	clients            = make(map[net.Conn]*ClientInfo)
-	allowedUsernames   = make(map[string]bool)
+	clients            = make(map[string]*ClientInfo) // keyed by ClientInfo.id, not by conn
+	nextClientID       int64
 	groups             = make(map[string]*GroupInfo)
 	clientsMutex       sync.RWMutex
-	allowedUsersMutex  sync.RWMutex
 	groupsMutex        sync.RWMutex
-	chatHistory        []string
-	chatHistoryMutex   sync.Mutex
 	chatLogFileHandler *os.File
+	historyMgr         *history.Manager
+	limitsConfig       = defaultLimitsConfig
+	globalConnThrottle *connThrottle
 	webClientPath      = "clients/web"
 	httpServerPort     = ":8081" // Port for the web client
+
+	tcpListener      net.Listener
+	httpSrv          *http.Server
+	sessionWG        sync.WaitGroup
+	shuttingDown     int32 // set via atomic.CompareAndSwapInt32 from Shutdown
+	shutdownComplete = make(chan struct{})
 )
 
-func loadAllowedUsers() {
-	allowedUsersMutex.Lock()
-	defer allowedUsersMutex.Unlock()
+// groupsForMember returns the names of every group username belongs to, for
+// use when replaying history a reconnecting user missed.
+func groupsForMember(username string) []string {
+	groupsMutex.RLock()
+	defer groupsMutex.RUnlock()
+
+	var memberOf []string
+	for name, g := range groups {
+		for _, m := range g.members {
+			if m == username {
+				memberOf = append(memberOf, name)
+				break
+			}
+		}
+	}
+	return memberOf
+}
+
+// wsJSONFrame is the shape of a message sent to a client that negotiated
+// the ws-json capability, in place of the line-oriented text protocol.
+type wsJSONFrame struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+	TS   string `json:"ts"`
+}
 
-	file, err := os.Open(ALLOWED_USERS_FILE)
-	if err != nil {
-		log.Printf(
-			"Warning: Could not open %s: %v. No users will be allowed by default.",
-			ALLOWED_USERS_FILE,
-			err,
-		)
+// encodeForClient applies the capabilities client negotiated during CAP
+// negotiation to a raw outgoing protocol line: prefixing an @time= tag for
+// server-time, or re-framing the line as JSON for ws-json. A client with no
+// negotiated caps gets the line back unchanged, preserving the legacy wire
+// format for clients that never spoke CAP LS at all.
+func encodeForClient(client *ClientInfo, line string) string {
+	if client.hasCap("ws-json") {
+		frame := wsJSONFrame{
+			Kind: "msg",
+			Text: strings.TrimSuffix(line, "\n"),
+			TS:   time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		}
+		encoded, err := json.Marshal(frame)
+		if err != nil {
+			log.Printf("Error encoding ws-json frame for %s: %v", client.username, err)
+			return line
+		}
+		return string(encoded)
+	}
+	if client.hasCap("server-time") {
+		ts := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+		return fmt.Sprintf("@time=%s %s", ts, line)
+	}
+	return line
+}
+
+// formatHistoryEntry renders a history.Entry the same way a live message of
+// that kind would have looked on the wire, prefixed with its ISO-8601
+// server timestamp so the client can render "you missed N messages".
+func formatHistoryEntry(e history.Entry) string {
+	ts := e.Timestamp.UTC().Format(time.RFC3339)
+	switch e.Kind {
+	case history.KindDM:
+		return fmt.Sprintf("[%s] (DM from %s): %s", ts, e.From, e.Text)
+	case history.KindGroup:
+		return fmt.Sprintf("[%s] (#%s from %s): %s", ts, e.Target, e.From, e.Text)
+	default:
+		return fmt.Sprintf("[%s] %s: %s", ts, e.From, e.Text)
+	}
+}
+
+// chatHistoryVerbFor returns the verb a live message of e's kind would
+// have used on the wire, so a CHATHISTORY batch line looks like the
+// PRIVMSG/GROUPMSG a client would have seen if it had been online.
+func chatHistoryVerbFor(kind history.Kind) string {
+	switch kind {
+	case history.KindDM:
+		return "PRIVMSG"
+	case history.KindGroup:
+		return "GROUPMSG"
+	default:
+		return "GLOBAL"
+	}
+}
+
+// formatChatHistoryLine renders e as a message-tags-framed line:
+// "@msgid=<id>;time=<RFC3339> :<from> <verb> <target> :<text>". This is
+// the same tag+source+verb+params+trailing shape
+// internal/client/protocol.Message.Encode produces; it's hand-formatted
+// here rather than built through that package so the server doesn't need
+// to import something under internal/client for one string.
+func formatChatHistoryLine(e history.Entry, target string) string {
+	return fmt.Sprintf(
+		"@msgid=%d;time=%s :%s %s %s :%s",
+		e.ID,
+		e.Timestamp.UTC().Format(time.RFC3339),
+		e.From,
+		chatHistoryVerbFor(e.Kind),
+		target,
+		e.Text,
+	)
+}
+
+// nextChatHistoryBatchID generates a process-local ID for a CHATHISTORY
+// BATCH bracket, only needed to be unique for the lifetime of one
+// response (the client only compares a BATCH -id against the +id that
+// most recently opened it).
+var nextChatHistoryBatchID int64
+
+func newChatHistoryBatchID() string {
+	return fmt.Sprintf("ch%d", atomic.AddInt64(&nextChatHistoryBatchID, 1))
+}
+
+// handleChatHistoryCommand implements "CHATHISTORY LATEST|BEFORE|BETWEEN
+// <target> ...", replying with a BATCH-framed run of tagged lines (see
+// formatChatHistoryLine) bracketed by "BATCH +id chathistory <target>"
+// and "BATCH -id", so a client can tell a coherent scrollback batch apart
+// from live traffic that might arrive while it's being sent - the same
+// role IRCv3's draft/chathistory BATCH type plays. <target> is "-" for
+// the global buffer, a group name, or a username for that DM peer (see
+// history.Manager.entriesFor - a DM target only ever resolves to the
+// conversation between the requester and target, so this can't be used
+// to read someone else's DMs).
+func handleChatHistoryCommand(client *ClientInfo, cmd string) {
+	parts := strings.Fields(cmd)
+	if len(parts) < 3 {
+		sendToClient(client, "System: Usage: CHATHISTORY LATEST|BEFORE|BETWEEN <target> ...")
 		return
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	loadedCount := 0
-	// Clear existing to support potential reload logic in future
-	allowedUsernames = make(map[string]bool)
-	for scanner.Scan() {
-		username := strings.TrimSpace(scanner.Text())
-		if username != "" {
-			if len(username) >= USERNAME_MAX_LEN {
-				log.Printf(
-					"Warning: Username '%s' in %s exceeds max length and will be ignored.",
-					username,
-					ALLOWED_USERS_FILE,
-				)
-				continue
+	subcommand := parts[1]
+	target := parts[2]
+	lookupTarget := target
+	if target == "-" {
+		lookupTarget = ""
+	}
+	groupMemberships := groupsForMember(client.username)
+
+	var entries []history.Entry
+	switch subcommand {
+	case "LATEST":
+		n := DEFAULT_HISTORY_COUNT
+		if len(parts) >= 4 {
+			if v, err := strconv.Atoi(parts[3]); err == nil && v > 0 {
+				n = v
 			}
-			allowedUsernames[username] = true
-			loadedCount++
 		}
-	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading %s: %v", ALLOWED_USERS_FILE, err)
-	}
-	log.Printf("Loaded %d allowed usernames from %s.", loadedCount, ALLOWED_USERS_FILE)
-	if loadedCount > 0 {
-		for u := range allowedUsernames {
-			log.Printf("  - %s", u)
+		if n > MAX_HISTORY_COUNT {
+			n = MAX_HISTORY_COUNT
+		}
+		entries = historyMgr.LatestFor(client.username, lookupTarget, n, groupMemberships)
+	case "BEFORE":
+		if len(parts) < 4 {
+			sendToClient(client, "System: Usage: CHATHISTORY BEFORE <target> <msgid> [count]")
+			return
+		}
+		before, err := strconv.ParseUint(parts[3], 10, 64)
+		if err != nil {
+			sendToClient(client, "System: Invalid msgid for CHATHISTORY BEFORE.")
+			return
+		}
+		n := DEFAULT_HISTORY_COUNT
+		if len(parts) >= 5 {
+			if v, err := strconv.Atoi(parts[4]); err == nil && v > 0 {
+				n = v
+			}
+		}
+		if n > MAX_HISTORY_COUNT {
+			n = MAX_HISTORY_COUNT
 		}
+		entries = historyMgr.BeforeFor(client.username, lookupTarget, before, n, groupMemberships)
+	case "BETWEEN":
+		if len(parts) < 5 {
+			sendToClient(client, "System: Usage: CHATHISTORY BETWEEN <target> <fromID> <toID> [count]")
+			return
+		}
+		fromID, fromErr := strconv.ParseUint(parts[3], 10, 64)
+		toID, toErr := strconv.ParseUint(parts[4], 10, 64)
+		if fromErr != nil || toErr != nil {
+			sendToClient(client, "System: Invalid msgid range for CHATHISTORY BETWEEN.")
+			return
+		}
+		n := MAX_HISTORY_COUNT
+		if len(parts) >= 6 {
+			if v, err := strconv.Atoi(parts[5]); err == nil && v > 0 {
+				n = v
+			}
+		}
+		if n > MAX_HISTORY_COUNT {
+			n = MAX_HISTORY_COUNT
+		}
+		entries = historyMgr.BetweenFor(client.username, lookupTarget, fromID, toID, n, groupMemberships)
+	default:
+		sendToClient(client, "System: Usage: CHATHISTORY LATEST|BEFORE|BETWEEN <target> ...")
+		return
 	}
+
+	batchID := newChatHistoryBatchID()
+	sendToClient(client, fmt.Sprintf("BATCH +%s chathistory %s", batchID, target))
+	for _, e := range entries {
+		sendToClient(client, formatChatHistoryLine(e, target))
+	}
+	sendToClient(client, "BATCH -"+batchID)
 }
 
-func isUsernameAllowed(username string) bool {
-	allowedUsersMutex.RLock()
-	defer allowedUsersMutex.RUnlock()
-	_, ok := allowedUsernames[username]
-	return ok
+// newClientID returns a unique, process-local session identifier used as
+// the key into the clients map.
+func newClientID() string {
+	return fmt.Sprintf("sess-%d", atomic.AddInt64(&nextClientID, 1))
 }
 
 func loadGroups() {
@@ -130,7 +497,9 @@ func loadGroups() {
 
 	scanner := bufio.NewScanner(file)
 	loadedCount := 0
-	// Clear existing to support potential reload logic in future
+	// Start from a fresh map each call so SIGHUP/RELOAD reloads don't carry
+	// stale entries forward; reloadGroups diffs against the old map before
+	// this replaces it.
 	groups = make(map[string]*GroupInfo)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -172,9 +541,9 @@ func loadGroups() {
 					)
 					continue
 				}
-				// Optional: Check if member is an allowed user
-				// if !isUsernameAllowed(m) {
-				//    log.Printf("Warning: Member '%s' in group '%s' (%s) is not an allowed user and will be ignored.", m, groupName, GROUPS_FILE)
+				// Optional: Check if member is a known user
+				// if _, ok := lookupUser(m); !ok {
+				//    log.Printf("Warning: Member '%s' in group '%s' (%s) is not a known user and will be ignored.", m, groupName, GROUPS_FILE)
 				//    continue
 				// }
 				group.members = append(group.members, m)
@@ -224,228 +593,303 @@ func logChatMessage(message string) {
 	}
 }
 
-func addMessageToHistory(message string) {
-	chatHistoryMutex.Lock()
-	defer chatHistoryMutex.Unlock()
-	// Ensure message has a newline for consistent history format
-	if !strings.HasSuffix(message, "\n") {
-		message += "\n"
-	}
-	chatHistory = append(chatHistory, message)
-	if len(chatHistory) > MAX_HISTORY_LINES {
-		chatHistory = chatHistory[len(chatHistory)-MAX_HISTORY_LINES:]
-	}
-}
-
+// sendToClient enqueues message onto client's outbound send queue rather
+// than writing to the transport inline, so one slow TCP or WebSocket peer
+// can't block a broadcastMessage call (and thus every other client) while
+// clientsMutex's RLock is held. The actual write happens on clientWriter,
+// client's dedicated writer goroutine; see sendqueue.go.
+//
+// The enqueue itself must never block: broadcastMessage calls this once per
+// client while holding clientsMutex's RLock, so waiting here for room would
+// stall every other recipient behind whichever client stalls first - the
+// exact thing the send queue exists to prevent. A full queue is handled with
+// a non-blocking default branch instead: the message is dropped (the client
+// is already falling behind, so it's getting evicted shortly regardless),
+// and sendStalledSince records when the stall started. Once a client has
+// been stalled for longer than sendQueueFullTimeout, it's evicted - checked
+// here, across calls, rather than via a single blocking wait.
 func sendToClient(client *ClientInfo, message string) {
-	if client == nil || client.writer == nil {
-		log.Println("Attempted to send to nil client or client with nil writer.")
-		return
-	}
-	// Ensure message has a newline for client protocol
-	if !strings.HasSuffix(message, "\n") {
-		message += "\n"
-	}
-	_, err := client.writer.WriteString(message)
-	if err != nil {
-		log.Printf(
-			"Error sending message to %s (%s): %v",
-			client.username,
-			client.conn.RemoteAddr().String(),
-			err,
-		)
-		// Consider closing connection or marking client for removal
+	if client == nil || client.transport == nil {
+		log.Println("Attempted to send to nil client or client with nil transport.")
 		return
 	}
-	err = client.writer.Flush()
-	if err != nil {
-		log.Printf(
-			"Error flushing writer for %s (%s): %v",
-			client.username,
-			client.conn.RemoteAddr().String(),
-			err,
-		)
+	message = encodeForClient(client, message)
+
+	select {
+	case client.sendQueue <- []byte(message):
+		atomic.StoreInt64(&client.sendStalledSince, 0)
+	default:
+		now := time.Now().UnixNano()
+		stalledSince := atomic.LoadInt64(&client.sendStalledSince)
+		if stalledSince == 0 {
+			atomic.CompareAndSwapInt64(&client.sendStalledSince, 0, now)
+		} else if time.Duration(now-stalledSince) > sendQueueFullTimeout {
+			// Run the eviction itself in its own goroutine: callers like
+			// broadcastMessage reach sendToClient while holding
+			// clientsMutex's RLock, and evictSlowClient needs the write
+			// lock to remove the client from the registry.
+			go evictSlowClient(client)
+		}
 	}
 }
 
-func broadcastMessage(message string, excludeConn net.Conn) {
+// broadcastMessage sends a message to every active client except the one
+// identified by excludeID (pass "" to exclude nobody). It works the same
+// for TCP and WebSocket clients since both are stored in the same registry.
+func broadcastMessage(message string, excludeID string) {
+	start := time.Now()
+	defer func() { recordBroadcast(time.Since(start)) }()
+
 	clientsMutex.RLock()
 	defer clientsMutex.RUnlock()
-	// Ensure message has a newline
 	if !strings.HasSuffix(message, "\n") {
 		message += "\n"
 	}
-	for conn, client := range clients {
-		if client.active && conn != excludeConn {
+	for id, client := range clients {
+		if client.active && id != excludeID {
 			sendToClient(client, message)
 		}
 	}
 }
 
-func handleConnection(conn net.Conn) {
-	log.Printf("New connection attempt from: %s", conn.RemoteAddr().String())
+// findActiveClientByUsername looks up a logged-in client by username across
+// both transports. Callers must not hold clientsMutex.
+func findActiveClientByUsername(username string) (*ClientInfo, bool) {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	for _, c := range clients {
+		if c.active && c.username == username {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// runSession drives a single client's lifecycle - login handshake, history
+// replay, and command dispatch - identically whether transport is backed by
+// TCP or WebSocket. This replaces the old duplicated handleConnection /
+// handleWebSocketConnection bodies.
+// runSession drives one client connection end to end. bearerToken, when
+// non-empty, is a JWT the client already presented out of band (the
+// WebSocket upgrade's Authorization header or access_token query param)
+// and lets the client skip straight past the REQ_USERNAME/SASL exchange;
+// see authenticateBearer in jwt.go. TCP clients that want the same
+// shortcut send "AUTH <token>" as their first line instead of a username.
+func runSession(transport Transport, bearerToken string) {
+	remoteAddr := transport.RemoteAddr()
+	log.Printf("New %s connection attempt from: %s", transport.Kind(), remoteAddr)
+
 	client := &ClientInfo{
-		conn:   conn,
-		reader: bufio.NewReader(conn),
-		writer: bufio.NewWriter(conn),
-		active: false,
+		id:        newClientID(),
+		transport: transport,
+		active:    false,
+		fakelag:   NewFakelag(limitsConfig.FakelagWindow, limitsConfig.FakelagBurst, limitsConfig.FakelagCooldown),
+		verbLimit: ratelimit.NewLimiter(limitsConfig.FakelagWindow, float64(limitsConfig.FakelagBurst), ratelimit.DefaultCosts),
+		sendQueue: make(chan []byte, sendQueueSize),
 	}
 
+	sessionWG.Add(1)
+	defer sessionWG.Done()
+
+	clientsMutex.Lock()
+	clients[client.id] = client
+	clientsMutex.Unlock()
+
+	sessionDone := make(chan struct{})
+	defer close(sessionDone)
+
+	go clientWriter(client, sessionDone)
+
 	defer func() {
-		conn.Close()
+		transport.Close()
 		clientsMutex.Lock()
-		// Check if the client was ever added (it should be)
-		if c, ok := clients[conn]; ok {
-			// Use the username from the map, as client.username might not be set if login failed early
-			usernameForLog := c.username
-			if usernameForLog == "" {
-				usernameForLog = "[unauthenticated]"
-			}
-			isActive := c.active
-
-			delete(clients, conn)
-			clientsMutex.Unlock() // Unlock before logging and broadcasting
-
-			if isActive {
-				log.Printf(
-					"User %s (%s) disconnected.",
-					usernameForLog,
-					conn.RemoteAddr().String(),
-				)
-				systemMsg := fmt.Sprintf("System: %s has left the chat.", usernameForLog)
-				logChatMessage(systemMsg)
-				addMessageToHistory(systemMsg)
-				broadcastMessage(systemMsg, nil) // Broadcast to all remaining
-			} else {
-				log.Printf(
-					"Connection from %s (user: %s) closed before completing login or was rejected.",
-					conn.RemoteAddr().String(),
-					usernameForLog,
-				)
-			}
+		delete(clients, client.id)
+		clientsMutex.Unlock()
+
+		if client.active {
+			log.Printf("User %s (%s) disconnected.", client.username, remoteAddr)
+			historyMgr.Touch(client.username) // lastSeen = now, so a later reconnect replays only what it missed
+			systemMsg := fmt.Sprintf("System: %s has left the chat.", client.username)
+			logChatMessage(systemMsg)
+			historyMgr.RecordGlobal("System", fmt.Sprintf("%s has left the chat.", client.username))
+			broadcastMessage(systemMsg, "")
 		} else {
-			clientsMutex.Unlock() // Ensure unlock if client wasn't found (should not happen)
 			log.Printf(
-				"Connection from %s closed, but client was not found in active map.",
-				conn.RemoteAddr().String(),
+				"Connection from %s closed before completing login or was rejected.",
+				remoteAddr,
 			)
 		}
 	}()
 
-	clientsMutex.Lock()
-	clients[conn] = client
-	clientsMutex.Unlock()
+	negotiateCapabilities(client)
 
-	sendToClient(client, "REQ_USERNAME")
-	usernameLine, err := client.reader.ReadString('\n')
-	if err != nil {
-		if err != io.EOF { // Don't be too verbose for normal disconnects
-			log.Printf(
-				"Error reading username from %s: %v",
-				conn.RemoteAddr().String(),
-				err,
-			)
+	var username string
+	var resumed bool
+	if bearerToken != "" {
+		u, ok := authenticateBearer(client, bearerToken, remoteAddr)
+		if !ok {
+			return
 		}
-		return // This will trigger defer, cleaning up the client
-	}
-	username := strings.TrimSpace(usernameLine)
+		username = u
+	} else {
+		sendToClient(client, "REQ_USERNAME")
+		usernameLine, err := transport.Recv()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading username from %s: %v", remoteAddr, err)
+			}
+			return
+		}
+		trimmed := strings.TrimSpace(usernameLine)
 
-	if username == "" {
-		sendToClient(client, "BAD_USERNAME\nUsername cannot be empty.")
-		log.Printf("Client %s sent empty username.", conn.RemoteAddr().String())
-		return
-	}
-	if len(username) >= USERNAME_MAX_LEN {
-		sendToClient(client, "BAD_USERNAME\nUsername too long.")
-		log.Printf(
-			"Client %s sent username too long: %s",
-			conn.RemoteAddr().String(),
-			username,
-		)
-		return
-	}
-	if !isUsernameAllowed(username) {
-		sendToClient(client, "NOT_ALLOWED\nUsername not on allowed list.")
-		log.Printf(
-			"Username '%s' from %s is not allowed. Rejecting.",
-			username,
-			conn.RemoteAddr().String(),
-		)
-		return
-	}
+		if strings.HasPrefix(trimmed, "AUTH ") {
+			u, ok := authenticateBearer(client, strings.TrimSpace(strings.TrimPrefix(trimmed, "AUTH ")), remoteAddr)
+			if !ok {
+				return
+			}
+			username = u
+		} else if strings.HasPrefix(trimmed, "RESUME ") {
+			u, ok := resumeSession(client, trimmed, remoteAddr)
+			if !ok {
+				return
+			}
+			username = u
+			resumed = true
+		} else {
+			username = trimmed
 
-	// Check if username is already in use by another active client
-	clientsMutex.RLock()
-	alreadyExists := false
-	for _, existingClient := range clients {
-		// Check existingClient.conn != conn to allow a user to reconnect if their old session is still being cleaned up
-		// but primarily, check active status and username.
-		if existingClient.active && existingClient.username == username && existingClient.conn != conn {
-			alreadyExists = true
-			break
+			if username == "" {
+				sendToClient(client, "BAD_USERNAME\nUsername cannot be empty.")
+				log.Printf("Client %s sent empty username.", remoteAddr)
+				return
+			}
+			if len(username) >= USERNAME_MAX_LEN {
+				sendToClient(client, "BAD_USERNAME\nUsername too long.")
+				log.Printf("Client %s sent username too long: %s", remoteAddr, username)
+				return
+			}
+			rec, ok := lookupUser(username)
+			if !ok {
+				sendToClient(client, "NOT_ALLOWED\nUsername not recognized.")
+				log.Printf("Username '%s' from %s is not a known user. Rejecting.", username, remoteAddr)
+				return
+			}
+			if _, exists := findActiveClientByUsername(username); exists {
+				sendToClient(client, "BAD_USERNAME\nUsername already in use.")
+				log.Printf("Client %s tried to use username '%s' which is already active.", remoteAddr, username)
+				return
+			}
+			if !authenticateClient(client, rec) {
+				sendToClient(client, "AUTH_FAILED\nAuthentication failed.")
+				log.Printf("Authentication failed for '%s' from %s.", username, remoteAddr)
+				return
+			}
+
+			clientsMutex.Lock()
+			client.username = username
+			client.active = true
+			client.isOperator = rec.Operator
+			clientsMutex.Unlock()
+
+			log.Printf("Username '%s' (authenticated) received for %s.", username, remoteAddr)
 		}
 	}
-	clientsMutex.RUnlock()
 
-	if alreadyExists {
-		sendToClient(client, "BAD_USERNAME\nUsername already in use.")
-		log.Printf(
-			"Client %s (%s) tried to use username '%s' which is already active.",
-			conn.RemoteAddr().String(),
-			username,
-			username,
-		)
-		return
+	if resumed {
+		sendToClient(client, fmt.Sprintf("RESUME_OK %s", username))
+	} else {
+		sendToClient(client, fmt.Sprintf("Welcome, %s!", username))
 	}
-	// Update client info under lock
-	clientsMutex.Lock()
-	client.username = username // Set username in the map's copy too
-	client.active = true
-	clients[conn] = client // Re-assign to update the map's value if ClientInfo is a value type (it is)
-	clientsMutex.Unlock()
-
-	log.Printf(
-		"Username '%s' (allowed) received for %s.",
-		username,
-		conn.RemoteAddr().String(),
-	)
-	sendToClient(client, fmt.Sprintf("Welcome, %s!", username))
-
-	chatHistoryMutex.Lock()
-	if len(chatHistory) > 0 {
-		sendToClient(client, "--- Recent Chat History ---")
-		for _, histMsg := range chatHistory {
-			sendToClient(client, histMsg) // histMsg already has newline
+	// Issued after every login and every resume, and single-use: a
+	// resumed session's token from before this one is already consumed
+	// by resumeSession, so the client always has exactly one good token
+	// in hand for its next unexpected disconnect.
+	sendToClient(client, "RESUME_TOKEN "+issueResumeToken(username))
+
+	memberOf := groupsForMember(username)
+	missed := historyMgr.ReplayFor(username, memberOf)
+	if len(missed) > 0 {
+		sendToClient(client, fmt.Sprintf("--- You missed %d message(s) ---", len(missed)))
+		for _, e := range missed {
+			sendToClient(client, formatHistoryEntry(e))
 		}
 		sendToClient(client, "--- End of History ---")
 	}
-	chatHistoryMutex.Unlock()
 
 	joinMsg := fmt.Sprintf("System: %s has joined the chat.", username)
 	logChatMessage(joinMsg)
-	addMessageToHistory(joinMsg)
-	broadcastMessage(joinMsg, conn)
+	historyMgr.RecordGlobal("System", fmt.Sprintf("%s has joined the chat.", username))
+	broadcastMessage(joinMsg, client.id)
+
+	// Keep lastSeen reasonably fresh for long-lived sessions so a crash
+	// mid-session doesn't force a full-buffer replay on the next login.
+	go func() {
+		ticker := time.NewTicker(LAST_SEEN_TOUCH_EVERY)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				historyMgr.Touch(username)
+			case <-sessionDone:
+				return
+			}
+		}
+	}()
 
 	for {
-		message, err := client.reader.ReadString('\n')
+		message, err := transport.Recv()
 		if err != nil {
-			// Normal EOF or connection closed by peer is not an "error" to spam logs with
-			// It will be handled by the defer block.
-			if err != io.EOF && !strings.Contains(err.Error(), "use of closed network connection") && !strings.Contains(err.Error(), "connection reset by peer") {
-				log.Printf(
-					"Error reading from %s (%s): %v",
-					client.username,
-					conn.RemoteAddr().String(),
-					err,
-				)
+			if err != io.EOF &&
+				!strings.Contains(err.Error(), "use of closed network connection") &&
+				!strings.Contains(err.Error(), "connection reset by peer") &&
+				websocket.CloseStatus(err) == -1 {
+				log.Printf("Error reading from %s (%s): %v", client.username, remoteAddr, err)
 			}
 			break
 		}
 
 		fullMessageCmd := strings.TrimSpace(message)
-		// rawMessageWithNewline := message // Retain original for broadcasting if needed
+		if client.caps["message-tags"] && strings.HasPrefix(fullMessageCmd, "@") {
+			// Client negotiated message-tags (see negotiateCapabilities),
+			// so a leading "@key=value;..." section may be present ahead
+			// of the command itself. The server doesn't do anything with
+			// tags yet beyond tolerating them, so just strip it off
+			// before the usual prefix-based dispatch below.
+			if sp := strings.IndexByte(fullMessageCmd, ' '); sp >= 0 {
+				fullMessageCmd = fullMessageCmd[sp+1:]
+			}
+		}
+		log.Printf("Received from %s: %s", client.username, fullMessageCmd)
 
-		log.Printf("Received from %s: %s", client.username, strings.TrimSpace(message))
+		if !client.fakelag.Touch() {
+			sendToClient(client, "System: rate limited, slow down")
+			continue
+		}
+
+		verb := "GLOBAL"
+		switch {
+		case strings.HasPrefix(fullMessageCmd, "PRIVMSG "):
+			verb = "PRIVMSG"
+		case strings.HasPrefix(fullMessageCmd, "GROUPMSG "):
+			verb = "GROUPMSG"
+		case strings.HasPrefix(fullMessageCmd, "HISTORY"):
+			verb = "HISTORY"
+		case strings.HasPrefix(fullMessageCmd, "CHATHISTORY "):
+			verb = "CHATHISTORY"
+		case fullMessageCmd == "PING":
+			verb = "PING"
+		}
+		// fakelag above is a blunt per-connection flood gate (any message
+		// costs the same); verbLimit layers a second, cost-aware gate on
+		// top so an expensive HISTORY query or a GROUPMSG fan-out drains
+		// the bucket faster than a cheap PRIVMSG, and tells the client
+		// exactly how long to back off via a structured FAIL line instead
+		// of the terse "slow down" fakelag sends.
+		if ok, retryAfter := client.verbLimit.Allow(verb); !ok {
+			sendToClient(client, fmt.Sprintf("FAIL RATELIMIT %s %d", verb, retryAfter.Milliseconds()))
+			continue
+		}
+		recordMessageReceived()
 
 		if strings.HasPrefix(fullMessageCmd, "PRIVMSG ") {
 			parts := strings.SplitN(fullMessageCmd, " ", 3)
@@ -456,37 +900,15 @@ func handleConnection(conn net.Conn) {
 			recipientUsername := parts[1]
 			dmText := parts[2]
 
-			var recipientClient *ClientInfo
-			foundRecipient := false
-			clientsMutex.RLock()
-			for _, rc := range clients {
-				if rc.active && rc.username == recipientUsername {
-					recipientClient = rc
-					foundRecipient = true
-					break
-				}
-			}
-			clientsMutex.RUnlock()
-
-			if foundRecipient && recipientClient != nil {
-				dmToRecipient := fmt.Sprintf("(DM from %s): %s", client.username, dmText)
-				sendToClient(recipientClient, dmToRecipient)
-				dmToSender := fmt.Sprintf("(DM to %s): %s", recipientUsername, dmText)
-				sendToClient(client, dmToSender)
-
-				dmLog := fmt.Sprintf(
-					"DM from %s to %s: %s",
-					client.username,
-					recipientUsername,
-					dmText,
-				)
+			if recipientClient, found := findActiveClientByUsername(recipientUsername); found {
+				sendToClient(recipientClient, fmt.Sprintf("(DM from %s): %s", client.username, dmText))
+				sendToClient(client, fmt.Sprintf("(DM to %s): %s", recipientUsername, dmText))
+
+				dmLog := fmt.Sprintf("DM from %s to %s: %s", client.username, recipientUsername, dmText)
 				logChatMessage(dmLog)
-				addMessageToHistory(dmLog)
+				historyMgr.RecordDM(client.username, recipientUsername, dmText)
 			} else {
-				sendToClient(
-					client,
-					fmt.Sprintf("System: User '%s' not found or is offline.", recipientUsername),
-				)
+				sendToClient(client, fmt.Sprintf("System: User '%s' not found or is offline.", recipientUsername))
 			}
 		} else if strings.HasPrefix(fullMessageCmd, "GROUPMSG ") {
 			parts := strings.SplitN(fullMessageCmd, " ", 3)
@@ -503,310 +925,182 @@ func handleConnection(conn net.Conn) {
 
 			if ok {
 				membersMessaged := 0
-				gmToSend := fmt.Sprintf(
-					"(#%s from %s): %s",
-					group.name,
-					client.username,
-					gmText,
-				)
-				clientsMutex.RLock()
+				gmToSend := fmt.Sprintf("(#%s from %s): %s", group.name, client.username, gmText)
 				for _, memberUsername := range group.members {
-					for _, c := range clients {
-						if c.active && c.username == memberUsername {
-							// Don't send to self if sender is part of group, unless desired
-							// if c.conn != client.conn {
-							sendToClient(c, gmToSend)
-							membersMessaged++
-							// }
-							break // Found this member, move to next member in group list
-						}
+					if memberClient, found := findActiveClientByUsername(memberUsername); found {
+						sendToClient(memberClient, gmToSend)
+						membersMessaged++
 					}
 				}
-				clientsMutex.RUnlock()
 
-				confirmationToSender := fmt.Sprintf("(To #%s): %s", group.name, gmText)
-				sendToClient(client, confirmationToSender)
+				sendToClient(client, fmt.Sprintf("(To #%s): %s", group.name, gmText))
 
-				gmLog := fmt.Sprintf(
-					"GROUPMSG to #%s from %s: %s",
-					group.name,
-					client.username,
-					gmText,
-				)
+				gmLog := fmt.Sprintf("GROUPMSG to #%s from %s: %s", group.name, client.username, gmText)
 				logChatMessage(gmLog)
-				addMessageToHistory(gmLog)
+				historyMgr.RecordGroup(client.username, group.name, gmText)
 				log.Printf("%s (%d members messaged)", gmLog, membersMessaged)
 			} else {
 				sendToClient(client, fmt.Sprintf("System: Group '#%s' not found.", groupNameReq))
 			}
+		} else if strings.HasPrefix(fullMessageCmd, "HISTORY") {
+			handleHistoryCommand(client, fullMessageCmd)
+		} else if strings.HasPrefix(fullMessageCmd, "CHATHISTORY ") {
+			handleChatHistoryCommand(client, fullMessageCmd)
+		} else if fullMessageCmd == "PING" {
+			// Application-level keepalive (see ClientCore.SetKeepalive):
+			// answered directly, having already passed both the fakelag
+			// and verbLimit checks above, the latter at PING's tiny cost.
+			sendToClient(client, "PONG")
+		} else if fullMessageCmd == "RELOAD" {
+			if !client.isOperator {
+				sendToClient(client, "System: RELOAD is restricted to operators.")
+				continue
+			}
+			log.Printf("Config reload requested by operator '%s'.", client.username)
+			reloadConfig()
+			sendToClient(client, "System: Config reload complete.")
 		} else {
 			// Global message, ensure original message (with newline) is used for formatting
 			globalMsg := fmt.Sprintf("%s: %s", client.username, message) // message already has \n
 			logChatMessage(strings.TrimSuffix(globalMsg, "\n"))          // Log without double newline
-			addMessageToHistory(strings.TrimSuffix(globalMsg, "\n"))
-			broadcastMessage(globalMsg, nil) // Send to all, including sender
+			historyMgr.RecordGlobal(client.username, strings.TrimSuffix(fullMessageCmd, "\n"))
+			// echo-message defaults to opt-in: a client only sees its own
+			// global message reflected back if it negotiated that cap.
+			broadcastMessage(globalMsg, client.id)
+			if client.hasCap("echo-message") {
+				sendToClient(client, globalMsg)
+			}
 		}
 	}
 }
 
-// serveWs handles websocket requests from the peer.
-func serveWs(w http.ResponseWriter, r *http.Request) {
-	log.Printf("WebSocket: Incoming connection attempt from %s", r.RemoteAddr)
-	wsConn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		// Subprotocols:       []string{"tincan-chat"}, // Optional: if you define subprotocols
-		InsecureSkipVerify: false, // Set to true if using self-signed certs for WSS locally (not recommended for prod)
-		OriginPatterns:     nil,   // nil allows all origins, or specify patterns like ["localhost:*", "yourdomain.com"]
-	})
-	if err != nil {
-		log.Printf("WebSocket: Accept error from %s: %v", r.RemoteAddr, err)
-		// The library handles sending the HTTP error response.
-		return
-	}
-	// Use r.Context() for the websocket connection's context.
-	// It will be cancelled when the underlying HTTP connection is closed.
-	handleWebSocketConnection(r.Context(), wsConn, r.RemoteAddr)
-}
-
-// handleWebSocketConnection manages a single WebSocket client connection.
-// It mirrors the logic of handleConnection but for WebSockets.
-func handleWebSocketConnection(ctx context.Context, wsConn *websocket.Conn, remoteAddr string) {
-	log.Printf("WebSocket: Connection established with %s", remoteAddr)
-
-	// Create a wrapper for the websocket connection to somewhat mimic net.Conn for ClientInfo
-	// This is a simplification. A more robust solution might involve an interface.
-	client := &ClientInfo{
-		// conn:   wsConn, // wsConn is not a net.Conn. We'll handle reads/writes differently.
-		// reader: bufio.NewReader(wsConn), // Not directly applicable
-		// writer: bufio.NewWriter(wsConn), // Not directly applicable
-		active: false,
-		// We need a way to associate this wsConn with the client in the 'clients' map.
-		// For now, let's manage it slightly differently or adapt ClientInfo.
-		// Let's try to keep ClientInfo similar and handle I/O specially.
-		// A unique ID for the wsConn might be needed if we put it in the global clients map.
-		// For now, this function will be self-contained for the client's lifecycle.
-	}
-	// For broadcasting, we'd need to register this client.
-	// Let's use a temporary structure for this client for now.
-	// This part needs careful thought on how to integrate with the existing client management.
-
-	// Simplified client management for this example:
-	// We'll need to adapt the global 'clients' map or have a separate one for WebSockets,
-	// or make ClientInfo more generic.
-	// For now, let's focus on the single connection lifecycle.
-
-	// Defer cleanup for this specific WebSocket connection
-	defer func() {
-		wsConn.Close(websocket.StatusNormalClosure, "Connection closed by server")
-		log.Printf("WebSocket: Connection with %s (user: %s) closed.", remoteAddr, client.username)
-		// If this client was registered in a global map, remove it here.
-		// And broadcast departure message.
-		if client.active {
-			// This requires ClientInfo to be in the global map and accessible.
-			// This part needs to be integrated with the global clients map and mutex.
-			// For now, conceptual:
-			clientsMutex.Lock()
-			// Find and delete client by wsConn or a unique ID if we adapt the clients map.
-			// For simplicity, let's assume we'd have a way to remove it.
-			// delete(clients, client.conn) // This 'conn' would need to be the key
-			clientsMutex.Unlock()
-
-			systemMsg := fmt.Sprintf("System: %s has left the chat.\n", client.username)
-			logChatMessage(systemMsg)
-			addMessageToHistory(systemMsg)
-			// broadcastMessage(systemMsg, client.conn) // 'conn' needs to be the right type or ID
-			// Broadcasting to WebSockets also needs adaptation.
-			broadcastWebSocketMessage(systemMsg, wsConn) // A new broadcast function
-		}
-	}()
-
-	// Helper to send a message to this specific WebSocket client
-	sendToWsClient := func(msg string) error {
-		if !strings.HasSuffix(msg, "\n") {
-			msg += "\n" // Ensure newline for consistency if clients expect it
-		}
-		err := wsConn.Write(ctx, websocket.MessageText, []byte(msg))
-		if err != nil {
-			log.Printf("WebSocket: Error writing to %s (user: %s): %v", remoteAddr, client.username, err)
-		}
-		return err
-	}
+// negotiateCapabilities runs the IRCv3-inspired CAP LS/REQ/END exchange
+// before the username handshake, so the wire format can evolve without
+// breaking clients that never ask for anything beyond the plain line
+// protocol. It populates client.caps and returns once negotiation is either
+// complete or the client proves it doesn't speak CAP LS (by staying silent
+// past capNegotiationTimeout).
+func negotiateCapabilities(client *ClientInfo) {
+	client.caps = make(map[string]bool)
 
-	// Username Handshake
-	if err := sendToWsClient("REQ_USERNAME"); err != nil {
+	if err := client.transport.Send("CAP LS " + strings.Join(supportedCaps, " ")); err != nil {
 		return
 	}
 
-	msgType, usernameBytes, err := wsConn.Read(ctx)
+	line, err := client.transport.RecvTimeout(capNegotiationTimeout)
 	if err != nil {
-		log.Printf("WebSocket: Error reading username from %s: %v", remoteAddr, err)
+		// No CAP REQ arrived in time - legacy client, fall through to
+		// REQ_USERNAME with no capabilities enabled.
 		return
 	}
-	if msgType != websocket.MessageText {
-		log.Printf("WebSocket: Received non-text message for username from %s", remoteAddr)
-		wsConn.Close(websocket.StatusUnsupportedData, "Expected text message for username")
+
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "CAP REQ ") {
+		// Doesn't look like capability negotiation; proceed as legacy.
+		// We've consumed one line that wasn't a username, but a client
+		// that ignores CAP LS has no reason to send anything unprompted.
 		return
 	}
-	username := strings.TrimSpace(string(usernameBytes))
 
-	// ... (Username validation logic - same as in handleConnection)
-	if username == "" {
-		sendToWsClient("BAD_USERNAME\nUsername cannot be empty.")
-		return
+	requested := strings.TrimPrefix(trimmed, "CAP REQ ")
+	requested = strings.TrimPrefix(requested, ":")
+	var accepted []string
+	for _, tok := range strings.Fields(requested) {
+		for _, supported := range supportedCaps {
+			if tok == supported {
+				if tok == "ws-json" && client.transport.Kind() != "websocket" {
+					break // JSON framing is offered on WebSocket connections only
+				}
+				client.caps[tok] = true
+				accepted = append(accepted, tok)
+				break
+			}
+		}
 	}
-	if len(username) >= USERNAME_MAX_LEN {
-		sendToWsClient("BAD_USERNAME\nUsername too long.")
-		return
+	client.transport.Send("CAP ACK :" + strings.Join(accepted, " "))
+
+	// Block (no timeout) for CAP END; a client that got this far has
+	// committed to finishing the handshake.
+	if endLine, err := client.transport.Recv(); err != nil || strings.TrimSpace(endLine) != "CAP END" {
+		log.Printf("Client %s did not send CAP END cleanly, proceeding anyway.", client.transport.RemoteAddr())
 	}
-	if !isUsernameAllowed(username) {
-		sendToWsClient("NOT_ALLOWED\nUsername not on allowed list.")
+}
+
+// handleHistoryCommand implements "HISTORY <target> <count>", letting a
+// connected client pull older lines on demand instead of waiting for a
+// reconnect. <target> is "-" for the global buffer, a group client is a
+// member of, or the client's own username for their DM history - anything
+// else (including another user's name) yields no history, since
+// Manager.History only ever resolves requester's own userBufs entry.
+func handleHistoryCommand(client *ClientInfo, cmd string) {
+	parts := strings.Fields(cmd)
+	if len(parts) < 2 {
+		sendToClient(client, "System: Usage: HISTORY <target|-> [count]")
 		return
 	}
-	// Check if username is already in use (needs access to global clients map)
-	clientsMutex.RLock()
-	alreadyExists := false
-	for _, existingClient := range clients { // This assumes 'clients' can hold WebSocket clients or a common type
-		if existingClient.active && existingClient.username == username {
-			alreadyExists = true
-			break
+
+	target := parts[1]
+	if target == "-" {
+		target = ""
+	}
+	count := DEFAULT_HISTORY_COUNT
+	if len(parts) >= 3 {
+		if n, err := strconv.Atoi(parts[2]); err == nil && n > 0 {
+			count = n
 		}
 	}
-	clientsMutex.RUnlock()
-	if alreadyExists {
-		sendToWsClient("BAD_USERNAME\nUsername already in use.")
-		return
+	if count > MAX_HISTORY_COUNT {
+		count = MAX_HISTORY_COUNT
 	}
 
-	client.username = username
-	client.active = true
-	// TODO: Add this client to a global map for broadcasting and management
-	// This is a critical part for full functionality.
-	// For now, we proceed with the single client's lifecycle.
-	// Example:
-	// client.conn = &wsNetConn{ws: wsConn, remote: net.TCPAddrFromAddr(wsConn.RemoteAddr())} // Wrap wsConn
-	// clientsMutex.Lock()
-	// clients[client.conn] = client
-	// clientsMutex.Unlock()
-
-	log.Printf("WebSocket: User '%s' (allowed) logged in from %s.", username, remoteAddr)
-	sendToWsClient(fmt.Sprintf("Welcome, %s!", username))
-
-	// Send recent history
-	chatHistoryMutex.Lock()
-	if len(chatHistory) > 0 {
-		sendToWsClient("--- Recent Chat History ---")
-		for _, histMsg := range chatHistory {
-			sendToWsClient(histMsg) // histMsg already has newline
-		}
-		sendToWsClient("--- End of History ---")
+	entries := historyMgr.History(client.username, target, count, groupsForMember(client.username))
+	if len(entries) == 0 {
+		sendToClient(client, fmt.Sprintf("System: No history for '%s'.", parts[1]))
+		return
 	}
-	chatHistoryMutex.Unlock()
-
-	joinMsg := fmt.Sprintf("System: %s has joined the chat.\n", username)
-	logChatMessage(joinMsg)
-	addMessageToHistory(joinMsg)
-	// broadcastMessage(joinMsg, client.conn) // Needs adapted broadcast
-	broadcastWebSocketMessage(joinMsg, wsConn) // Broadcast to others, excluding self
-
-	// Message processing loop
-	for {
-		msgType, p, err := wsConn.Read(ctx)
-		if err != nil {
-			if websocket.CloseStatus(err) == websocket.StatusNormalClosure ||
-				websocket.CloseStatus(err) == websocket.StatusGoingAway {
-				log.Printf("WebSocket: Client %s (user: %s) disconnected normally.", remoteAddr, client.username)
-			} else if errors.Is(err, io.EOF) {
-				log.Printf("WebSocket: Client %s (user: %s) EOF.", remoteAddr, client.username)
-			} else {
-				log.Printf("WebSocket: Error reading from %s (user: %s): %v", remoteAddr, client.username, err)
-			}
-			break // Exit loop, defer will handle cleanup
-		}
-
-		if msgType != websocket.MessageText {
-			log.Printf("WebSocket: Received non-text message from %s (user: %s). Ignoring.", remoteAddr, client.username)
-			continue
-		}
-
-		message := string(p)
-		fullMessageCmd := strings.TrimSpace(message) // For parsing command
-		// rawMessageWithNewline := message // Retain original for broadcasting
-
-		log.Printf("WebSocket: Received from %s: %s", client.username, fullMessageCmd)
-
-		// ... (Command parsing logic: PRIVMSG, GROUPMSG, Global - similar to handleConnection)
-		// This part needs to be carefully adapted.
-		// sendToClient calls need to become sendToWsClient.
-		// Broadcasts need to go to both TCP and WebSocket clients.
-
-		if strings.HasPrefix(fullMessageCmd, "PRIVMSG ") {
-			// ... (DM logic, find recipient (could be TCP or WS), send message) ...
-			// This requires a unified way to find and send to clients.
-			sendToWsClient("System: DM processing not fully implemented for WS yet.\n")
-		} else if strings.HasPrefix(fullMessageCmd, "GROUPMSG ") {
-			// ... (Group message logic) ...
-			sendToWsClient("System: GroupMSG processing not fully implemented for WS yet.\n")
-		} else { // Global message
-			globalMsg := fmt.Sprintf("%s: %s", client.username, message) // message might need newline adjustment
-			if !strings.HasSuffix(globalMsg, "\n") {
-				globalMsg += "\n"
-			}
-			logChatMessage(strings.TrimSuffix(globalMsg, "\n"))
-			addMessageToHistory(strings.TrimSuffix(globalMsg, "\n"))
-			// broadcastMessage(globalMsg, client.conn) // Needs adapted broadcast
-			broadcastWebSocketMessage(globalMsg, nil) // Broadcast to ALL WS clients (and ideally TCP too)
-		}
+	sendToClient(client, fmt.Sprintf("--- History: %s (%d) ---", parts[1], len(entries)))
+	for _, e := range entries {
+		sendToClient(client, formatHistoryEntry(e))
 	}
+	sendToClient(client, "--- End of History ---")
 }
 
-// TODO: This is a placeholder. A proper implementation requires refactoring
-// the global 'clients' map and ClientInfo to handle both TCP and WebSocket clients.
-var wsClients = make(map[*websocket.Conn]*ClientInfo) // Temporary, illustrative
-var wsClientsMutex sync.RWMutex
+func handleConnection(conn net.Conn) {
+	recordConnection()
+	runSession(newTCPTransport(conn), "")
+}
 
-func broadcastWebSocketMessage(message string, excludeConn *websocket.Conn) {
-	wsClientsMutex.RLock()
-	defer wsClientsMutex.RUnlock()
+// serveWs handles websocket requests from the peer.
+func serveWs(w http.ResponseWriter, r *http.Request) {
+	log.Printf("WebSocket: Incoming connection attempt from %s", r.RemoteAddr)
 
-	if !strings.HasSuffix(message, "\n") {
-		message += "\n"
+	ip := ipFromRemoteAddr(r.RemoteAddr)
+	if !globalConnThrottle.Allow(ip) {
+		log.Printf("WebSocket: Rejecting connection from %s: too many connections from this IP.", ip)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
 	}
 
-	for conn, client := range wsClients {
-		if client.active && conn != excludeConn {
-			// Assuming client.username is set
-			err := conn.Write(context.Background(), websocket.MessageText, []byte(message))
-			if err != nil {
-				log.Printf("WebSocket: Error broadcasting to %s: %v", client.username, err)
-				// Consider removing client on repeated errors
-			}
-		}
-	}
-	// Also, iterate over TCP clients and send to them
-	clientsMutex.RLock()
-	defer clientsMutex.RUnlock()
-	for _, tcpClient := range clients {
-		if tcpClient.active { // How to exclude if excludeConn was a TCP conn?
-			// This shows the complexity of a mixed broadcast.
-			// For now, this placeholder only broadcasts to WS clients.
-			// A proper solution needs a unified client list or two separate loops.
-			if _, ok := tcpClient.conn.(net.Conn); ok { // Check if it's a TCP client
-				// sendToClient(tcpClient, message) // This is the existing function for TCP
-			}
-		}
+	// A bearer token presented on the upgrade (Authorization header or
+	// ?access_token=) lets the client skip the REQ_USERNAME/SASL exchange
+	// entirely; see authenticateBearer in jwt.go.
+	bearerToken := bearerTokenFromRequest(r)
+
+	wsConn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		InsecureSkipVerify: false, // Set to true if using self-signed certs for WSS locally (not recommended for prod)
+		OriginPatterns:     nil,   // nil allows all origins, or specify patterns like ["localhost:*", "yourdomain.com"]
+	})
+	if err != nil {
+		log.Printf("WebSocket: Accept error from %s: %v", r.RemoteAddr, err)
+		// The library handles sending the HTTP error response.
+		return
 	}
-	log.Printf("Placeholder: Broadcasted to WS clients: %s", strings.TrimSpace(message))
+	recordConnection()
+	// Use r.Context() for the websocket connection's context. It is
+	// cancelled when the underlying HTTP connection is closed.
+	runSession(newWSTransport(r.Context(), wsConn, r.RemoteAddr, r.TLS), bearerToken)
 }
 
-// In handleWebSocketConnection, after successful login:
-// wsClientsMutex.Lock()
-// wsClients[wsConn] = client // Add to our temporary map
-// wsClientsMutex.Unlock()
-//
-// In the defer func of handleWebSocketConnection:
-// wsClientsMutex.Lock()
-// delete(wsClients, wsConn)
-// wsClientsMutex.Unlock()
-
 func startWebServer(serveWeb bool, webPath string, httpPort string) {
 	if !serveWeb {
 		return
@@ -823,42 +1117,74 @@ func startWebServer(serveWeb bool, webPath string, httpPort string) {
 		return
 	}
 
+	webCfg := loadWebServerConfig()
+
 	mux := http.NewServeMux() // Create a new ServeMux
 	fileServer := http.FileServer(http.Dir(absWebPath))
-	mux.Handle("/", fileServer)    // Serve static files
-	mux.HandleFunc("/ws", serveWs) // Handle WebSocket connections on /ws
-
-	log.Printf("Starting HTTP server for web client on port %s, serving files from %s", httpPort, absWebPath)
-	log.Printf("WebSocket endpoint available at ws://<host>%s/ws", httpPort)
+	mux.Handle("/", fileServer)                        // Serve static files
+	mux.HandleFunc("/ws", serveWs)                     // Handle WebSocket connections on /ws
+	mux.HandleFunc("/debug/limits", handleDebugLimits) // Expose current rate-limit state
+	mux.HandleFunc("/debug/queues", handleDebugQueues) // Expose per-client send queue depth and eviction count
+	mux.HandleFunc("/login", handleLogin)              // Exchange username/password for a JWT
+	mux.HandleFunc("/healthz", handleHealthz)          // Liveness probe
+	mux.HandleFunc("/metrics", handleMetrics)          // Prometheus text-format counters
+	mux.HandleFunc("/api/history", handleAPIHistory)   // Recent chat log lines as JSON
+
+	httpSrv = &http.Server{
+		Addr:           httpPort,
+		Handler:        mux,
+		ReadTimeout:    webCfg.ReadTimeout,
+		WriteTimeout:   webCfg.WriteTimeout,
+		IdleTimeout:    webCfg.IdleTimeout,
+		MaxHeaderBytes: webCfg.MaxHeaderBytes,
+	}
 
-	go func() {
-		// Use the mux with ListenAndServe
-		if err := http.ListenAndServe(httpPort, mux); err != nil {
-			if err != http.ErrServerClosed {
-				log.Printf("HTTP server ListenAndServe error: %v", err)
-			} else {
-				log.Println("HTTP server closed.")
+	runServer := func(listenAndServe func() error, scheme, wsScheme string) {
+		log.Printf("Starting %s server for web client on port %s, serving files from %s", scheme, httpPort, absWebPath)
+		log.Printf("WebSocket endpoint available at %s://<host>%s/ws", wsScheme, httpPort)
+		go func() {
+			if err := listenAndServe(); err != nil {
+				if err != http.ErrServerClosed {
+					log.Printf("HTTP server error: %v", err)
+				} else {
+					log.Println("HTTP server closed.")
+				}
 			}
+		}()
+	}
+
+	switch {
+	case webCfg.AutocertDir != "":
+		certManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(webCfg.AutocertHosts...),
+			Cache:      autocert.DirCache(webCfg.AutocertDir),
 		}
-	}()
+		httpSrv.TLSConfig = certManager.TLSConfig()
+		runServer(func() error { return httpSrv.ListenAndServeTLS("", "") }, "https", "wss")
+	case webCfg.TLSCertFile != "" && webCfg.TLSKeyFile != "":
+		runServer(func() error { return httpSrv.ListenAndServeTLS(webCfg.TLSCertFile, webCfg.TLSKeyFile) }, "https", "wss")
+	default:
+		runServer(httpSrv.ListenAndServe, "http", "ws")
+	}
 }
 
 // Start is the main entry point for the server
 func Start(serveWebClient bool) { // Changed from main
-	log.SetFlags(log.LstdFlags | log.Lshortfile) // Setup logging
+	log.SetFlags(log.Lshortfile) // Timestamp comes from the logging.Writer log.SetOutput points at (see cmd/tincan-server)
 
 	// Start the web server if requested
 	// Use the global webClientPath and httpServerPort or make them configurable
 	startWebServer(serveWebClient, webClientPath, httpServerPort)
 
 	// Ensure config directory and files exist or provide clear errors
-	// For now, we rely on them being present as per loadAllowedUsers/loadGroups
+	// For now, we rely on them being present as per loadUsers/loadGroups
 	if _, err := os.Stat("config"); os.IsNotExist(err) {
 		log.Println(
 			"Warning: 'config' directory not found in current working directory. User and group files may not load.",
 		)
 		log.Println(
-			"Please ensure 'config/users.txt' and 'config/groups.txt' exist relative to where the server is run.",
+			"Please ensure 'config/users.json' and 'config/groups.txt' exist relative to where the server is run.",
 		)
 		// Optionally, create the directory:
 		// if err := os.MkdirAll("config", 0755); err != nil {
@@ -866,8 +1192,13 @@ func Start(serveWebClient bool) { // Changed from main
 		// }
 	}
 
-	loadAllowedUsers()
+	loadUsers()
 	loadGroups()
+	loadJWTSecret()
+	watchForReloadSignal()
+	historyMgr = history.NewManager(history.NewJSONStore(LAST_SEEN_FILE), HISTORY_BUFFER_SIZE)
+	limitsConfig = loadLimitsConfig()
+	globalConnThrottle = newConnThrottle(limitsConfig.ConnPerIPPerMin, time.Minute)
 
 	var err error
 	chatLogFileHandler, err = os.OpenFile(
@@ -878,15 +1209,27 @@ func Start(serveWebClient bool) { // Changed from main
 	if err != nil {
 		log.Fatalf("Error opening chat log file %s: %v", CHAT_LOG_FILE, err)
 	}
-	defer chatLogFileHandler.Close()
 
 	listener, err := net.Listen("tcp", PORT)
 	if err != nil {
 		log.Fatalf("Failed to listen on port %s: %v", PORT, err)
 	}
+	tcpListener = listener
 	defer listener.Close()
 	log.Printf("Server listening for connections on port %s...", PORT)
 
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received %s, shutting down gracefully.", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_GRACE_PERIOD)
+		defer cancel()
+		if err := Shutdown(ctx); err != nil {
+			log.Printf("Graceful shutdown: %v", err)
+		}
+	}()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -898,17 +1241,84 @@ func Start(serveWebClient bool) { // Changed from main
 			}
 			// If it's not a temporary error, it might be serious (e.g. listener closed)
 			log.Printf("Failed to accept connection: %v", err)
-			// If listener.Close() was called, this loop will break.
-			// For other critical errors, we might need a way to signal shutdown.
-			// For now, if it's a non-temporary error, we might be in a bad state.
-			// Consider if this indicates the server should stop.
-			// If the error is "use of closed network connection", it means listener was closed.
 			if strings.Contains(err.Error(), "use of closed network connection") {
 				log.Println("Listener closed, shutting down accept loop.")
 				break
 			}
 			continue
 		}
+
+		ip := ipFromRemoteAddr(conn.RemoteAddr().String())
+		if !globalConnThrottle.Allow(ip) {
+			log.Printf("Rejecting connection from %s: too many connections from this IP.", ip)
+			conn.Close()
+			continue
+		}
 		go handleConnection(conn)
 	}
+
+	// The accept loop only ever breaks because the listener was closed,
+	// which in this codebase only happens from Shutdown; block here until
+	// it has finished draining sessions and flushing the chat log.
+	<-shutdownComplete
+}
+
+// Shutdown stops the server from accepting new connections, tells every
+// connected client it is going away (websocket clients get a 1001/Going
+// Away close frame), and waits up to ctx's deadline for in-flight
+// handleConnection/runSession goroutines to finish before flushing and
+// closing the chat log. It does not attempt a goagain-style zero-downtime
+// restart via re-exec and listener fd handoff on SIGHUP: this tree already
+// wires SIGHUP to the operator config-reload path (see watchForReloadSignal
+// in reload.go), so that signal can't also mean "restart" without breaking
+// reload. Shutdown only runs once; later calls return an error.
+func Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&shuttingDown, 0, 1) {
+		return fmt.Errorf("shutdown already in progress")
+	}
+	defer close(shutdownComplete)
+
+	if tcpListener != nil {
+		tcpListener.Close()
+	}
+	if httpSrv != nil {
+		if err := httpSrv.Shutdown(ctx); err != nil {
+			log.Printf("HTTP server shutdown error: %v", err)
+		}
+	}
+
+	clientsMutex.RLock()
+	sessions := make([]*ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		sessions = append(sessions, c)
+	}
+	clientsMutex.RUnlock()
+
+	for _, c := range sessions {
+		sendToClient(c, "System: Server is shutting down.")
+		if ws, ok := c.transport.(*wsTransport); ok {
+			ws.conn.Close(websocket.StatusGoingAway, "Server shutting down")
+		} else {
+			c.transport.Close()
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		sessionWG.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Printf("Shutdown deadline reached with sessions still draining.")
+	}
+
+	if chatLogFileHandler != nil {
+		chatLogFileHandler.Sync()
+		chatLogFileHandler.Close()
+	}
+
+	return ctx.Err()
 }
@@ -0,0 +1,109 @@
+// tincan/internal/server/webconfig.go
+package server
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebServerConfig controls startWebServer's *http.Server timeouts and how
+// (or whether) it terminates TLS.
+type WebServerConfig struct {
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+
+	TLSCertFile string // explicit cert; used if both this and TLSKeyFile are set
+	TLSKeyFile  string // explicit key; used if both this and TLSCertFile are set
+
+	AutocertDir   string   // autocert cache directory; enables autocert if set (takes priority over an explicit cert)
+	AutocertHosts []string // hostnames autocert is allowed to issue certs for
+}
+
+var defaultWebServerConfig = WebServerConfig{
+	ReadTimeout:    10 * time.Second,
+	WriteTimeout:   10 * time.Second,
+	IdleTimeout:    120 * time.Second,
+	MaxHeaderBytes: 1 << 20, // 1 MiB
+}
+
+const WEB_CONFIG_FILE = "config/webserver.yaml"
+
+// loadWebServerConfig reads config/webserver.yaml if present, falling back
+// to defaultWebServerConfig (plain HTTP, no TLS) for anything missing or
+// malformed - the same minimal flat "key: value" parser loadLimitsConfig
+// uses, so TLS/timeout knobs don't need a YAML library either.
+func loadWebServerConfig() WebServerConfig {
+	cfg := defaultWebServerConfig
+
+	file, err := os.Open(WEB_CONFIG_FILE)
+	if err != nil {
+		log.Printf(
+			"Warning: Could not open %s: %v. Using default web server settings (plain HTTP).",
+			WEB_CONFIG_FILE,
+			err,
+		)
+		return cfg
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			log.Printf("Skipping malformed webserver config line: %s", line)
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		switch key {
+		case "read_timeout_seconds":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.ReadTimeout = time.Duration(n) * time.Second
+			}
+		case "write_timeout_seconds":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.WriteTimeout = time.Duration(n) * time.Second
+			}
+		case "idle_timeout_seconds":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.IdleTimeout = time.Duration(n) * time.Second
+			}
+		case "max_header_bytes":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.MaxHeaderBytes = n
+			}
+		case "tls_cert_file":
+			cfg.TLSCertFile = value
+		case "tls_key_file":
+			cfg.TLSKeyFile = value
+		case "autocert_cache_dir":
+			cfg.AutocertDir = value
+		case "autocert_hosts":
+			var hosts []string
+			for _, h := range strings.Split(value, ",") {
+				h = strings.TrimSpace(h)
+				if h != "" {
+					hosts = append(hosts, h)
+				}
+			}
+			cfg.AutocertHosts = hosts
+		default:
+			log.Printf("Warning: Unknown key '%s' in %s, ignoring.", key, WEB_CONFIG_FILE)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading %s: %v", WEB_CONFIG_FILE, err)
+	}
+	log.Printf("Loaded web server config from %s.", WEB_CONFIG_FILE)
+	return cfg
+}